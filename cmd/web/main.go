@@ -1,71 +1,159 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"github.com/Seician/bookings/internal/config"
 	"github.com/Seician/bookings/internal/driver"
 	"github.com/Seician/bookings/internal/handlers"
+	"github.com/Seician/bookings/internal/health"
 	"github.com/Seician/bookings/internal/helpers"
+	"github.com/Seician/bookings/internal/icalsync"
+	"github.com/Seician/bookings/internal/logging"
+	"github.com/Seician/bookings/internal/mailer"
+	"github.com/Seician/bookings/internal/metrics"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/outbox"
+	"github.com/Seician/bookings/internal/payments"
 	"github.com/Seician/bookings/internal/render"
+	"github.com/Seician/bookings/internal/repository/dbrepo"
 	"github.com/alexedwards/scs/v2"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+var mailQueue *outbox.Outbox
+var probes *health.Checker
 
 // main is the main function
 func main() {
-	db, err := run()
+	db, err := run(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		fatal("can't start", err)
 	}
-	defer db.SQL.Close()
-	defer close(app.MailChan)
 
-	listenForEmail()
+	// background work runs until shutdown, and stops before the database is closed
+	background, stopBackground := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	// send the emails waiting in the outbox
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		mailQueue.Run(background)
+	}()
+
+	// import the calendars rooms have on other booking channels
+	if app.ICal.SyncInterval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			icalsync.New(&app, handlers.Repo.DB).Run(background)
+		}()
+	}
 
-	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
+	app.Logger.Info("starting application", "port", app.Port, "metrics", app.MetricsAddr, "env", app.Env)
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    app.Port,
 		Handler: routes(&app),
 	}
+	metricsSrv := &http.Server{
+		Addr:    app.MetricsAddr,
+		Handler: metricsRoutes(&app),
+	}
+
+	// stop on ctrl-c, and on the SIGTERM sent on deploy
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	go func() {
+		serveErr <- metricsSrv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		fatal("can't serve", err)
+	case <-signals.Done():
+	}
+
+	app.Logger.Info("shutting down")
+	err = shutdown([]*http.Server{srv, metricsSrv}, db, stopBackground, &workers)
+	if err != nil {
+		fatal("can't shut down", err)
+	}
+	app.Logger.Info("stopped")
+}
+
+// fatal logs why the application can't go on, and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// shutdown stops the application in order, within app.ShutdownTimeout: the requests in flight are
+// finished, the background work is stopped, the emails already due are sent, and the database is
+// closed last
+func shutdown(servers []*http.Server, db *driver.DB, stopBackground context.CancelFunc, workers *sync.WaitGroup) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			app.Logger.Error("can't finish the requests in flight", "addr", srv.Addr, "error", err)
+		}
+	}
+
+	stopBackground()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		app.Logger.Error("background work did not stop in time")
+	}
 
-	err = srv.ListenAndServe()
+	err := mailQueue.Flush(ctx)
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("can't send the queued emails", "error", err)
 	}
 
+	return db.SQL.Close()
 }
 
-func run() (*driver.DB, error) {
+func run(args []string) (*driver.DB, error) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	// change this to true when in production
-	app.InProduction = false
-
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	// read flags, environment and database.yml
+	err := app.Load(args)
+	if err != nil {
+		return nil, err
+	}
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// JSON for the log collector in production, text otherwise. Whatever still logs through the
+	// log package goes through it too
+	app.Logger = logging.New(os.Stdout, app.InProduction, app.LogLevel)
+	slog.SetDefault(app.Logger)
 
 	// set up the session
 	session = scs.New()
@@ -76,28 +164,75 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	app.Metrics = metrics.New()
+
+	// the fake provider is the only one so far, and Load keeps it out of production
+	if app.Payments.Provider == "fake" {
+		app.PaymentProvider = payments.NewFake(app.Payments.WebhookSecret)
+	}
+
 	// connect to database
-	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL("root:Alfabetromanesc1@tcp(127.0.0.1:3306)/bookings")
+	app.Logger.Info("connecting to database", "dialect", app.DB.Dialect)
+	var db *driver.DB
+	switch app.DB.Dialect {
+	case driver.Postgres:
+		db, err = driver.ConnectPostgres(app.DB.DSN)
+	case driver.SQLite:
+		db, err = driver.ConnectSQLite(app.DB.DSN)
+		if err == nil {
+			err = prepareSQLite(db, app.DB.Migrations)
+		}
+	default:
+		db, err = driver.ConnectSQL(app.DB.DSN)
+	}
 	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
 
-	log.Print("Connecting to database")
-
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
+
+	mc, err := render.CreateMailTemplateCache()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create mail template cache: %w", err)
+	}
+
+	app.MailTemplates = mc
+
+	m, err := mailer.New(&app)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up the mailer: %w", err)
+	}
+
+	err = app.Metrics.RegisterDB(db.SQL)
+	if err != nil {
+		return nil, err
+	}
 
 	repo := handlers.NewRepo(&app, db)
+	err = app.Metrics.RegisterMailQueue(repo.DB.CountMailByStatus)
+	if err != nil {
+		return nil, err
+	}
+	mailQueue = outbox.New(&app, repo.DB, m)
+	app.MailQueue = mailQueue
+	probes = health.New(&app, db, mailQueue)
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
 	return db, nil
 }
+
+// prepareSQLite builds the schema from the migrations folder and seeds sample data
+func prepareSQLite(db *driver.DB, migrations string) error {
+	err := dbrepo.MigrateSQLite(db.SQL, migrations)
+	if err != nil {
+		return err
+	}
+	return dbrepo.SeedSQLite(db.SQL)
+}
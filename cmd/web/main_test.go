@@ -1,11 +1,80 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"github.com/Seician/bookings/internal/mailer"
+	"github.com/Seician/bookings/internal/models"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
 
 func Test_run(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_DIALECT", "sqlite")
+	t.Setenv("BOOKINGS_DB_DSN", ":memory:")
+	t.Setenv("BOOKINGS_MIGRATIONS", "../../migrations")
 
-	_, err := run()
+	_, err := run(nil)
 	if err != nil {
 		t.Errorf("failed run")
 	}
 }
+
+func Test_shutdown(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_DIALECT", "sqlite")
+	t.Setenv("BOOKINGS_DB_DSN", ":memory:")
+	t.Setenv("BOOKINGS_MIGRATIONS", "../../migrations")
+
+	db, err := run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := &mailer.Memory{}
+	mailQueue.Mailer = sent
+
+	// a request that is still running when the shutdown starts
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(l) }()
+
+	responses := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		responses <- err
+	}()
+	<-started
+
+	// an email queued while no worker is running is sent before the database closes
+	err = mailQueue.Enqueue(context.Background(), models.MailData{To: "john@smith.com", Subject: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = shutdown([]*http.Server{srv}, db, func() {}, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = <-responses; err != nil {
+		t.Errorf("the request in flight failed: %v", err)
+	}
+	if len(sent.Messages()) != 1 {
+		t.Error("the queued email was not sent")
+	}
+	if db.SQL.Ping() == nil {
+		t.Error("the database is still open")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/Seician/bookings/internal/helpers"
+	"github.com/Seician/bookings/internal/logging"
 	"github.com/justinas/nosurf"
 	"net/http"
 )
@@ -9,6 +10,8 @@ import (
 // NoSurf is the csrf protection middleware
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// the payment provider can't know our csrf token, its webhooks are signed instead
+	csrfHandler.ExemptPath("/payments/webhook")
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -24,6 +27,16 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// LogUser adds the id of the user logged in to the logs of the request
+func LogUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if id := session.GetInt(request.Context(), "user_id"); id != 0 {
+			logging.SetUserID(request.Context(), id)
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		if !helpers.IsAuthenticated(request) {
@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/Seician/bookings/internal/config"
 	"github.com/Seician/bookings/internal/handlers"
+	"github.com/Seician/bookings/internal/logging"
+	"github.com/Seician/bookings/internal/payments"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"net/http"
@@ -11,37 +13,99 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
+	// every request gets an id its logs carry, and is logged once served. The probes are polled
+	// too often to be worth more than a debug line
+	mux.Use(logging.Middleware(app.Logger, "/healthz", "/readyz"))
+	// measured outside the recoverer, so requests that panic count as the 500 they are answered with
+	mux.Use(app.Metrics.Middleware)
 	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
+	// the probes answer without a session or CSRF cookie, so polling them leaves no sessions behind
+	mux.Get("/healthz", probes.Healthz)
+	mux.Get("/readyz", probes.Readyz)
+	mux.Get("/version", probes.Version)
 
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	// so do the room feeds, polled by calendar sites, and the payment provider: neither keeps a
+	// session, and the webhook couldn't send a CSRF token anyway
+	mux.Get("/ical/{slug}.ics", handlers.Repo.RoomCalendarFeed)
+	if app.PaymentProvider != nil {
+		mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+	}
+	if fake, ok := app.PaymentProvider.(*payments.Fake); ok && !app.InProduction {
+		mux.Mount(payments.FakePath, http.StripPrefix(payments.FakePath, fake))
+	}
 
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room/{id}", handlers.Repo.BookRoom)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(NoSurf)
+		mux.Use(SessionLoad)
+		mux.Use(LogUser)
 
-	mux.Get("/contact", handlers.Repo.Contact)
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/rooms/{slug}", handlers.Repo.Room)
+		mux.Get("/generals-quarters", handlers.Repo.RedirectToRoom)
+		mux.Get("/majors-suite", handlers.Repo.RedirectToRoom)
 
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		mux.Get("/search-availability", handlers.Repo.Availability)
+		mux.Post("/search-availability", handlers.Repo.PostAvailability)
+		mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+		mux.Get("/book-room/{id}", handlers.Repo.BookRoom)
 
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Get("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.Logout)
+		mux.Get("/contact", handlers.Repo.Contact)
 
-	mux.Route("/admin", func(mux chi.Router) {
-		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/make-reservation/payment", handlers.Repo.ReservationPayment)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		mux.Get("/cancel-reservation/{token}", handlers.Repo.ShowCancelReservation)
+		mux.Post("/cancel-reservation/{token}", handlers.Repo.PostCancelReservation)
+
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+		mux.Get("/user/logout", handlers.Repo.Logout)
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(Auth)
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+			mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Get("/blocks", handlers.Repo.AdminBlocks)
+			mux.Post("/blocks", handlers.Repo.AdminPostBlock)
+			mux.Post("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+			mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+			mux.Post("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+			mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+			mux.Post("/rooms/{id}/rates/{rate}/delete", handlers.Repo.AdminDeleteRoomRate)
+			mux.Post("/rooms/{id}/calendars", handlers.Repo.AdminPostRoomCalendar)
+			mux.Post("/rooms/{id}/calendars/{calendar}/delete", handlers.Repo.AdminDeleteRoomCalendar)
+			mux.Post("/rooms/{id}/calendars/{calendar}/sync", handlers.Repo.AdminSyncRoomCalendar)
+			mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+			mux.Get("/promo-codes/{id}", handlers.Repo.AdminShowPromoCode)
+			mux.Post("/promo-codes/{id}", handlers.Repo.AdminPostPromoCode)
+			mux.Post("/promo-codes/{id}/delete", handlers.Repo.AdminDeletePromoCode)
+			mux.Get("/mail", handlers.Repo.AdminMail)
+			mux.Get("/mail/{id}", handlers.Repo.AdminShowMail)
+			mux.Post("/mail/{id}/resend", handlers.Repo.AdminResendMail)
+			mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+			mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+			mux.Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+			mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
+			mux.Post("/reservation-status/{src}/{id}", handlers.Repo.AdminPostReservationStatus)
+		})
+		fileServer := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	})
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	return mux
 }
+
+// metricsRoutes serves /metrics on its own listener, at app.MetricsAddr, so the public never sees it
+func metricsRoutes(app *config.AppConfig) http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(middleware.Recoverer)
+	mux.Method(http.MethodGet, "/metrics", app.Metrics)
+	return mux
+}
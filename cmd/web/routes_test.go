@@ -3,7 +3,20 @@ package main
 import (
 	"fmt"
 	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/handlers"
+	"github.com/Seician/bookings/internal/health"
+	"github.com/Seician/bookings/internal/helpers"
+	"github.com/Seician/bookings/internal/ical"
+	"github.com/Seician/bookings/internal/logging"
+	"github.com/Seician/bookings/internal/metrics"
+	"github.com/Seician/bookings/internal/payments"
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +30,94 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+func TestRoutes_Metrics(t *testing.T) {
+	app := config.AppConfig{Metrics: metrics.New(), Logger: logging.New(io.Discard, false, slog.LevelInfo)}
+	session = scs.New()
+	mux := routes(&app)
+
+	for _, path := range []string{"/static/css/styles.css", "/static/js/app.js", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	metricsRoutes(&app).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	// requests are counted by the route they matched, not their path
+	for _, series := range []string{
+		`bookings_http_requests_total{code="404",method="GET",route="/static/*"} 2`,
+		`bookings_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`bookings_http_request_duration_seconds_count{method="GET",route="/static/*"} 2`,
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("did not find %s in the metrics", series)
+		}
+	}
+}
+
+func TestRoutes_Probes(t *testing.T) {
+	app := config.AppConfig{Metrics: metrics.New(), Logger: logging.New(io.Discard, false, slog.LevelInfo)}
+	session = scs.New()
+	probes = health.New(&app, nil, nil)
+	mux := routes(&app)
+
+	var tests = []struct {
+		path               string
+		expectedStatusCode int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+		{"/version", http.StatusOK},
+		// metrics are only served on their own listener
+		{"/metrics", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", e.path, nil))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.path, rr.Code, e.expectedStatusCode)
+		}
+		// probes stay out of the session and CSRF middleware
+		if cookie := rr.Header().Get("Set-Cookie"); cookie != "" {
+			t.Errorf("%s: set cookie %q", e.path, cookie)
+		}
+	}
+}
+
+func TestRoutes_Feeds(t *testing.T) {
+	app := config.AppConfig{Metrics: metrics.New(), Logger: logging.New(io.Discard, false, slog.LevelInfo)}
+	app.ICal.FeedSecret = "feed-secret"
+	app.PaymentProvider = payments.NewFake("test-secret")
+	session = scs.New()
+	helpers.NewHelpers(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	mux := routes(&app)
+
+	feed := "/ical/generals-quarters.ics?token=" + ical.FeedToken("feed-secret", 1)
+
+	var tests = []struct {
+		method             string
+		path               string
+		expectedStatusCode int
+	}{
+		{"GET", feed, http.StatusOK},
+		{"POST", "/payments/webhook", http.StatusBadRequest},
+		{"GET", payments.FakePath + "/3ds/nothing", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(e.method, e.path, strings.NewReader("{}")))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s %s: got status %d, wanted %d", e.method, e.path, rr.Code, e.expectedStatusCode)
+		}
+		// calendar sites and the payment provider get no session, and the webhook needs no CSRF token
+		if cookie := rr.Header().Get("Set-Cookie"); cookie != "" {
+			t.Errorf("%s %s: set cookie %q", e.method, e.path, cookie)
+		}
+	}
+}
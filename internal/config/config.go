@@ -1,19 +1,111 @@
 package config
 
 import (
+	"context"
+	"github.com/Seician/bookings/internal/metrics"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/payments"
 	"github.com/alexedwards/scs/v2"
 	"html/template"
-	"log"
+	"log/slog"
+	texttemplate "text/template"
+	"time"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache        bool
+	TemplateCache   map[string]*template.Template
+	MailTemplates   MailTemplateCache
+	Logger          *slog.Logger
+	LogLevel        slog.Level
+	InProduction    bool
+	Session         *scs.SessionManager
+	MailQueue       MailQueue
+	Env             string
+	Port            string
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+	DB              DBConfig
+	SMTP            SMTPConfig
+	Mail            MailConfig
+	Payments        PaymentsConfig
+	Property        PropertyConfig
+	ICal            ICalConfig
+	PaymentProvider payments.Provider
+	Metrics         *metrics.Metrics
+}
+
+// MailTemplateCache holds the parsed email templates by name, each with an HTML version and a
+// plain text alternative
+type MailTemplateCache struct {
+	HTML map[string]*template.Template
+	Text map[string]*texttemplate.Template
+}
+
+// DBConfig holds the database connection settings
+type DBConfig struct {
+	Dialect      string
+	DSN          string
+	Migrations   string
+	QueryTimeout time.Duration
+}
+
+// SMTPConfig holds the mail server settings. Encryption is none, starttls or tls, and Auth is how
+// the username and password are sent: plain, login or crammd5. Mail is signed for DKIMDomain when
+// the DKIM settings are given
+type SMTPConfig struct {
+	Host         string
+	Port         int
+	Username     string
+	Password     string
+	From         string
+	Encryption   string
+	Auth         string
+	DKIMDomain   string
+	DKIMSelector string
+	DKIMKeyFile  string
+}
+
+// MailConfig holds the settings of the mail outbox: how many workers send from it, how many
+// times a message is tried before it is given up on, and the Backend that sends it: smtp, file
+// to write .eml files to Dir, or log
+type MailConfig struct {
+	Workers     int
+	MaxAttempts int
+	Backend     string
+	Dir         string
+}
+
+// MailQueue takes emails to be sent in the background
+type MailQueue interface {
+	Enqueue(ctx context.Context, msg models.MailData) error
+}
+
+// PaymentsConfig holds the payment provider settings. DepositPercent is the part of a stay's
+// price taken when booking; with the "none" provider no deposit is taken
+type PaymentsConfig struct {
+	Provider       string
+	DepositPercent int
+	WebhookSecret  string
+}
+
+// PropertyConfig holds the details of the business printed on invoices. Prices include tax at
+// TaxPercent. Domain names the calendar events the site publishes, so they keep their ids
+// whichever host they are fetched from
+type PropertyConfig struct {
+	Name       string
+	Address    string
+	Email      string
+	Domain     string
+	TaxID      string
+	TaxPercent float64
+}
+
+// ICalConfig holds the calendar feed settings. Room feeds are only published when FeedSecret,
+// which signs their links, is set. The calendars of rooms on other channels are imported every
+// SyncInterval, or never when it is zero
+type ICalConfig struct {
+	FeedSecret   string
+	SyncInterval time.Duration
 }
@@ -0,0 +1,526 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Seician/bookings/internal/driver"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultConfigFile = "database.yml"
+
+// dbSection is one environment section of a soda style database.yml
+type dbSection struct {
+	Dialect  string `yaml:"dialect"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	URL      string `yaml:"url"`
+}
+
+// Load fills in the config from defaults, the YAML file, environment variables
+// and command-line flags (each one overriding the previous), then validates it
+func (a *AppConfig) Load(args []string) error {
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	fs.String("env", "", "Environment section to read from the config file (BOOKINGS_ENV)")
+	fs.String("config", "", "Path to the database.yml config file (BOOKINGS_CONFIG)")
+	fs.String("port", "", "Port to listen on (BOOKINGS_PORT)")
+	fs.String("metricsaddr", "", "Address serving /metrics, apart from the site (BOOKINGS_METRICS_ADDR)")
+	fs.String("shutdowntimeout", "", "How long to wait for requests and queued emails when stopping, e.g. 30s (BOOKINGS_SHUTDOWN_TIMEOUT)")
+	fs.String("production", "", "Application is in production (BOOKINGS_PRODUCTION)")
+	fs.String("cache", "", "Use template cache (BOOKINGS_USE_CACHE)")
+	fs.String("loglevel", "", "Least important level logged: debug, info, warn or error (BOOKINGS_LOG_LEVEL)")
+	fs.String("dbdialect", "", "Database dialect: mysql, postgres or sqlite (BOOKINGS_DB_DIALECT)")
+	fs.String("dsn", "", "Database connection string (BOOKINGS_DB_DSN)")
+	fs.String("migrations", "", "Migrations folder used to build a sqlite schema (BOOKINGS_MIGRATIONS)")
+	fs.String("dbtimeout", "", "Default deadline for a database query, e.g. 3s (BOOKINGS_DB_TIMEOUT)")
+	fs.String("smtphost", "", "SMTP host (BOOKINGS_SMTP_HOST)")
+	fs.String("smtpport", "", "SMTP port (BOOKINGS_SMTP_PORT)")
+	fs.String("smtpuser", "", "SMTP username (BOOKINGS_SMTP_USER)")
+	fs.String("smtppassword", "", "SMTP password (BOOKINGS_SMTP_PASSWORD)")
+	fs.String("smtpencryption", "", "SMTP encryption: none, starttls or tls (BOOKINGS_SMTP_ENCRYPTION)")
+	fs.String("smtpauth", "", "SMTP authentication: plain, login or crammd5 (BOOKINGS_SMTP_AUTH)")
+	fs.String("dkimdomain", "", "Domain the emails are DKIM signed for (BOOKINGS_DKIM_DOMAIN)")
+	fs.String("dkimselector", "", "DKIM selector of the signing key (BOOKINGS_DKIM_SELECTOR)")
+	fs.String("dkimkey", "", "PEM file with the DKIM private key (BOOKINGS_DKIM_KEY_FILE)")
+	fs.String("mailer", "", "How emails are sent: smtp, file or log (BOOKINGS_MAILER)")
+	fs.String("maildir", "", "Folder the file mailer writes .eml files to (BOOKINGS_MAIL_DIR)")
+	fs.String("mailfrom", "", "Sender of the emails the site sends (BOOKINGS_MAIL_FROM)")
+	fs.String("mailworkers", "", "Number of workers sending emails from the outbox (BOOKINGS_MAIL_WORKERS)")
+	fs.String("mailattempts", "", "Times an email is tried before it is given up on (BOOKINGS_MAIL_ATTEMPTS)")
+	fs.String("payments", "", "Payment provider: none or fake (BOOKINGS_PAYMENTS_PROVIDER)")
+	fs.String("deposit", "", "Percentage of the price taken as a deposit when booking (BOOKINGS_DEPOSIT_PERCENT)")
+	fs.String("paymentsecret", "", "Secret the payment provider signs webhooks with (BOOKINGS_PAYMENTS_WEBHOOK_SECRET)")
+	fs.String("propertyname", "", "Business name printed on invoices (BOOKINGS_PROPERTY_NAME)")
+	fs.String("propertyaddress", "", "Business address printed on invoices, lines separated by commas (BOOKINGS_PROPERTY_ADDRESS)")
+	fs.String("propertyemail", "", "Contact email printed on invoices (BOOKINGS_PROPERTY_EMAIL)")
+	fs.String("propertydomain", "", "Domain the ids of calendar events end in (BOOKINGS_PROPERTY_DOMAIN)")
+	fs.String("taxid", "", "Tax registration number printed on invoices (BOOKINGS_TAX_ID)")
+	fs.String("taxpercent", "", "Tax rate included in room prices, e.g. 7.5 (BOOKINGS_TAX_PERCENT)")
+	fs.String("icalsecret", "", "Secret that signs the links of the room calendar feeds (BOOKINGS_ICAL_SECRET)")
+	fs.String("icalsync", "", "How often to import room calendars from other channels, e.g. 15m, or 0 not to (BOOKINGS_ICAL_SYNC_INTERVAL)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	// lookup returns the flag if it was given, otherwise the environment variable
+	lookup := func(name, envVar string) (string, bool) {
+		if v, ok := flags[name]; ok {
+			return v, true
+		}
+		if v := os.Getenv(envVar); v != "" {
+			return v, true
+		}
+		return "", false
+	}
+
+	var problems []string
+
+	// defaults
+	a.Env = "development"
+	a.LogLevel = slog.LevelInfo
+	a.Port = ":8080"
+	// metrics are only for the monitoring next to the server, not for the public
+	a.MetricsAddr = "127.0.0.1:9090"
+	a.ShutdownTimeout = 30 * time.Second
+	a.DB = DBConfig{Migrations: "./migrations", QueryTimeout: 3 * time.Second}
+	a.SMTP = SMTPConfig{Host: "localhost", Port: 1025, Encryption: "none", Auth: "plain"}
+	a.Mail = MailConfig{Workers: 2, MaxAttempts: 8, Backend: "smtp", Dir: "./mail"}
+	// the fake provider only runs outside production, so its webhooks can be signed with a known secret
+	a.Payments = PaymentsConfig{Provider: "fake", DepositPercent: 20, WebhookSecret: "fake-webhook-secret"}
+	a.ICal = ICalConfig{SyncInterval: 15 * time.Minute}
+	a.Property = PropertyConfig{
+		Name:    "Fort Smythe Bed and Breakfast",
+		Address: "1 Harbour Road, Fort Smythe",
+		Email:   "me@yahoo.com",
+		Domain:  "fortsmythe.example",
+	}
+
+	if v, ok := lookup("env", "BOOKINGS_ENV"); ok {
+		a.Env = v
+	}
+
+	// the config file is optional unless one was asked for explicitly
+	configFile, explicit := lookup("config", "BOOKINGS_CONFIG")
+	if !explicit {
+		configFile = defaultConfigFile
+	}
+	section, err := readDBSection(configFile, a.Env)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+	case err != nil:
+		problems = append(problems, err.Error())
+	case section != nil:
+		a.DB.Dialect, a.DB.DSN, err = section.connection()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	a.InProduction = a.Env == "production"
+	if v, ok := lookup("production", "BOOKINGS_PRODUCTION"); ok {
+		a.InProduction, err = strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("production must be true or false, got %q", v))
+		}
+	}
+
+	a.UseCache = a.InProduction
+	if v, ok := lookup("cache", "BOOKINGS_USE_CACHE"); ok {
+		a.UseCache, err = strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cache must be true or false, got %q", v))
+		}
+	}
+
+	// the fake provider only takes test cards, so production takes no deposit until a real one is set up
+	if a.InProduction {
+		a.Payments = PaymentsConfig{Provider: "none"}
+	}
+
+	if v, ok := lookup("loglevel", "BOOKINGS_LOG_LEVEL"); ok {
+		err = a.LogLevel.UnmarshalText([]byte(v))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("log level must be debug, info, warn or error, got %q", v))
+		}
+	}
+	if v, ok := lookup("port", "BOOKINGS_PORT"); ok {
+		a.Port = ":" + strings.TrimPrefix(v, ":")
+	}
+	if v, ok := lookup("metricsaddr", "BOOKINGS_METRICS_ADDR"); ok {
+		a.MetricsAddr = v
+	}
+	if v, ok := lookup("shutdowntimeout", "BOOKINGS_SHUTDOWN_TIMEOUT"); ok {
+		a.ShutdownTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("shutdown timeout must be a duration like 30s, got %q", v))
+		}
+	}
+	if v, ok := lookup("dbdialect", "BOOKINGS_DB_DIALECT"); ok {
+		a.DB.Dialect = v
+	}
+	if v, ok := lookup("dsn", "BOOKINGS_DB_DSN"); ok {
+		a.DB.DSN = v
+	}
+	if v, ok := lookup("migrations", "BOOKINGS_MIGRATIONS"); ok {
+		a.DB.Migrations = v
+	}
+	if v, ok := lookup("dbtimeout", "BOOKINGS_DB_TIMEOUT"); ok {
+		a.DB.QueryTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("database timeout must be a duration like 3s, got %q", v))
+		}
+	}
+	if v, ok := lookup("smtphost", "BOOKINGS_SMTP_HOST"); ok {
+		a.SMTP.Host = v
+	}
+	if v, ok := lookup("smtpport", "BOOKINGS_SMTP_PORT"); ok {
+		a.SMTP.Port, err = strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("smtp port must be a number, got %q", v))
+		}
+	}
+	if v, ok := lookup("smtpuser", "BOOKINGS_SMTP_USER"); ok {
+		a.SMTP.Username = v
+	}
+	if v, ok := lookup("smtppassword", "BOOKINGS_SMTP_PASSWORD"); ok {
+		a.SMTP.Password = v
+	}
+	if v, ok := lookup("smtpencryption", "BOOKINGS_SMTP_ENCRYPTION"); ok {
+		a.SMTP.Encryption = v
+	}
+	if v, ok := lookup("smtpauth", "BOOKINGS_SMTP_AUTH"); ok {
+		a.SMTP.Auth = v
+	}
+	if v, ok := lookup("dkimdomain", "BOOKINGS_DKIM_DOMAIN"); ok {
+		a.SMTP.DKIMDomain = v
+	}
+	if v, ok := lookup("dkimselector", "BOOKINGS_DKIM_SELECTOR"); ok {
+		a.SMTP.DKIMSelector = v
+	}
+	if v, ok := lookup("dkimkey", "BOOKINGS_DKIM_KEY_FILE"); ok {
+		a.SMTP.DKIMKeyFile = v
+	}
+	if v, ok := lookup("mailer", "BOOKINGS_MAILER"); ok {
+		a.Mail.Backend = v
+	}
+	if v, ok := lookup("maildir", "BOOKINGS_MAIL_DIR"); ok {
+		a.Mail.Dir = v
+	}
+	if v, ok := lookup("payments", "BOOKINGS_PAYMENTS_PROVIDER"); ok {
+		a.Payments.Provider = v
+	}
+	if v, ok := lookup("deposit", "BOOKINGS_DEPOSIT_PERCENT"); ok {
+		a.Payments.DepositPercent, err = strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("deposit must be a number, got %q", v))
+		}
+	}
+	if v, ok := lookup("paymentsecret", "BOOKINGS_PAYMENTS_WEBHOOK_SECRET"); ok {
+		a.Payments.WebhookSecret = v
+	}
+	if v, ok := lookup("propertyname", "BOOKINGS_PROPERTY_NAME"); ok {
+		a.Property.Name = v
+	}
+	if v, ok := lookup("propertyaddress", "BOOKINGS_PROPERTY_ADDRESS"); ok {
+		a.Property.Address = v
+	}
+	if v, ok := lookup("propertyemail", "BOOKINGS_PROPERTY_EMAIL"); ok {
+		a.Property.Email = v
+	}
+	if v, ok := lookup("propertydomain", "BOOKINGS_PROPERTY_DOMAIN"); ok {
+		a.Property.Domain = v
+	}
+	// mail comes from the property unless a sender is given
+	a.SMTP.From = (&mail.Address{Name: a.Property.Name, Address: a.Property.Email}).String()
+	if v, ok := lookup("mailfrom", "BOOKINGS_MAIL_FROM"); ok {
+		a.SMTP.From = v
+	}
+	if v, ok := lookup("mailworkers", "BOOKINGS_MAIL_WORKERS"); ok {
+		a.Mail.Workers, err = strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("mail workers must be a number, got %q", v))
+		}
+	}
+	if v, ok := lookup("mailattempts", "BOOKINGS_MAIL_ATTEMPTS"); ok {
+		a.Mail.MaxAttempts, err = strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("mail attempts must be a number, got %q", v))
+		}
+	}
+	if v, ok := lookup("taxid", "BOOKINGS_TAX_ID"); ok {
+		a.Property.TaxID = v
+	}
+	if v, ok := lookup("taxpercent", "BOOKINGS_TAX_PERCENT"); ok {
+		a.Property.TaxPercent, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("tax percent must be a number, got %q", v))
+		}
+	}
+
+	if v, ok := lookup("icalsecret", "BOOKINGS_ICAL_SECRET"); ok {
+		a.ICal.FeedSecret = v
+	}
+	if v, ok := lookup("icalsync", "BOOKINGS_ICAL_SYNC_INTERVAL"); ok {
+		a.ICal.SyncInterval, err = time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("calendar sync interval must be a duration like 15m, got %q", v))
+		}
+	}
+
+	problems = append(problems, a.validate()...)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate returns a description of every setting that can't be used
+func (a *AppConfig) validate() []string {
+	var problems []string
+
+	port, err := strconv.Atoi(strings.TrimPrefix(a.Port, ":"))
+	if err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("port must be between 1 and 65535, got %q", a.Port))
+	}
+
+	_, metricsPort, err := net.SplitHostPort(a.MetricsAddr)
+	if n, convErr := strconv.Atoi(metricsPort); err != nil || convErr != nil || n < 1 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("metrics address must be a host and port like 127.0.0.1:9090, got %q", a.MetricsAddr))
+	} else if n == port {
+		problems = append(problems, "metrics must be served on another port than the site")
+	}
+
+	if a.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown timeout must be positive")
+	}
+
+	switch a.DB.Dialect {
+	case driver.MySQL, driver.Postgres, driver.SQLite:
+	case "":
+		problems = append(problems, fmt.Sprintf("no database configured for %q: set -dbdialect and -dsn, or add a section to %s", a.Env, defaultConfigFile))
+	default:
+		problems = append(problems, fmt.Sprintf("unknown database dialect %q", a.DB.Dialect))
+	}
+	if a.DB.Dialect != "" && a.DB.DSN == "" {
+		problems = append(problems, "database connection string is empty")
+	}
+
+	if a.DB.QueryTimeout <= 0 {
+		problems = append(problems, "database timeout must be positive")
+	}
+
+	switch a.Mail.Backend {
+	case "smtp":
+		problems = append(problems, a.SMTP.validate()...)
+	case "file":
+		if a.Mail.Dir == "" {
+			problems = append(problems, "mail folder is empty")
+		}
+	case "log":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown mailer %q", a.Mail.Backend))
+	}
+
+	if a.Mail.Workers < 1 {
+		problems = append(problems, fmt.Sprintf("mail workers must be at least 1, got %d", a.Mail.Workers))
+	}
+	if a.Mail.MaxAttempts < 1 {
+		problems = append(problems, fmt.Sprintf("mail attempts must be at least 1, got %d", a.Mail.MaxAttempts))
+	}
+
+	switch a.Payments.Provider {
+	case "none":
+		if a.Payments.DepositPercent != 0 {
+			problems = append(problems, "a deposit needs a payment provider")
+		}
+	case "fake":
+		if a.InProduction {
+			problems = append(problems, "the fake payment provider only takes test cards and can't be used in production")
+		}
+		// webhooks are signed with the secret, so without one anybody could sign them
+		if a.Payments.WebhookSecret == "" {
+			problems = append(problems, "payment webhook secret is empty")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown payment provider %q", a.Payments.Provider))
+	}
+	if a.Payments.DepositPercent < 0 || a.Payments.DepositPercent > 100 {
+		problems = append(problems, fmt.Sprintf("deposit must be between 0 and 100, got %d", a.Payments.DepositPercent))
+	}
+
+	if _, err := mail.ParseAddress(a.SMTP.From); err != nil {
+		problems = append(problems, fmt.Sprintf("mail sender must be an email address, got %q", a.SMTP.From))
+	}
+
+	if a.Property.Name == "" {
+		problems = append(problems, "property name is empty")
+	}
+	if a.Property.TaxPercent < 0 || a.Property.TaxPercent >= 100 {
+		problems = append(problems, fmt.Sprintf("tax percent must be at least 0 and below 100, got %g", a.Property.TaxPercent))
+	}
+
+	if a.ICal.SyncInterval < 0 {
+		problems = append(problems, "calendar sync interval can't be negative")
+	}
+
+	return problems
+}
+
+// validate returns a description of every mail server setting that can't be used
+func (s *SMTPConfig) validate() []string {
+	var problems []string
+
+	if s.Host == "" {
+		problems = append(problems, "smtp host is empty")
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("smtp port must be between 1 and 65535, got %d", s.Port))
+	}
+
+	switch s.Encryption {
+	case "none", "starttls", "tls":
+	default:
+		problems = append(problems, fmt.Sprintf("smtp encryption must be none, starttls or tls, got %q", s.Encryption))
+	}
+	switch s.Auth {
+	case "plain", "login", "crammd5":
+	default:
+		problems = append(problems, fmt.Sprintf("smtp authentication must be plain, login or crammd5, got %q", s.Auth))
+	}
+
+	// DKIM signing needs all three, or none of them
+	given := 0
+	for _, v := range []string{s.DKIMDomain, s.DKIMSelector, s.DKIMKeyFile} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 0 && given < 3 {
+		problems = append(problems, "dkim signing needs a domain, a selector and a key file")
+	}
+
+	return problems
+}
+
+// readDBSection reads the section for env from a soda style database.yml,
+// evaluating the env and envOr template helpers it may use
+func readDBSection(file, env string) (*dbSection, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := template.New(file).Funcs(template.FuncMap{
+		"env": os.Getenv,
+		"envOr": func(key, def string) string {
+			if v := os.Getenv(key); v != "" {
+				return v
+			}
+			return def
+		},
+	}).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	sections := map[string]*dbSection{}
+	err = yaml.Unmarshal(buf.Bytes(), &sections)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return sections[env], nil
+}
+
+// connection returns the dialect and the connection string the driver expects
+func (s *dbSection) connection() (string, string, error) {
+	if s.URL != "" {
+		return connectionFromURL(s.URL)
+	}
+
+	switch s.Dialect {
+	case driver.MySQL:
+		cfg := mysql.NewConfig()
+		cfg.User = s.User
+		cfg.Passwd = s.Password
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(valueOr(s.Host, "127.0.0.1"), valueOr(s.Port, "3306"))
+		cfg.DBName = s.Database
+		cfg.ParseTime = true
+		return driver.MySQL, cfg.FormatDSN(), nil
+	case driver.Postgres, "postgresql":
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(s.User, s.Password),
+			Host:     net.JoinHostPort(valueOr(s.Host, "127.0.0.1"), valueOr(s.Port, "5432")),
+			Path:     "/" + s.Database,
+			RawQuery: "sslmode=disable",
+		}
+		return driver.Postgres, u.String(), nil
+	case driver.SQLite, "sqlite3":
+		return driver.SQLite, s.Database, nil
+	default:
+		return "", "", fmt.Errorf("unknown database dialect %q", s.Dialect)
+	}
+}
+
+// connectionFromURL turns a soda style database url into a dialect and connection string
+func connectionFromURL(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return driver.Postgres, raw, nil
+	case "mysql":
+		cfg := mysql.NewConfig()
+		cfg.User = u.User.Username()
+		cfg.Passwd, _ = u.User.Password()
+		cfg.Net = "tcp"
+		cfg.Addr = u.Host
+		cfg.DBName = strings.TrimPrefix(u.Path, "/")
+		cfg.ParseTime = true
+		return driver.MySQL, cfg.FormatDSN(), nil
+	case "sqlite", "sqlite3":
+		return driver.SQLite, strings.TrimPrefix(raw, u.Scheme+"://"), nil
+	default:
+		return "", "", fmt.Errorf("unsupported database url scheme %q", u.Scheme)
+	}
+}
+
+func valueOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -3,12 +3,23 @@ package driver
 import (
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+	"strings"
 	"time"
 )
 
+// Supported database dialects
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
 // DB holds the database connection pool
 type DB struct {
-	SQL *sql.DB
+	SQL     *sql.DB
+	Dialect string
 }
 
 var dbConn = &DB{}
@@ -21,15 +32,56 @@ const maxDbLifeTime = 5 * time.Minute
 func ConnectSQL(dsn string) (*DB, error) {
 	database, err := NewDatabase(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+
+	return connect(database, MySQL)
+}
+
+// ConnectPostgres creates database pool for Postgres
+func ConnectPostgres(dsn string) (*DB, error) {
+	database, err := NewPostgresDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect(database, Postgres)
+}
+
+// ConnectSQLite creates database pool for an embedded SQLite file (or :memory:)
+func ConnectSQLite(dsn string) (*DB, error) {
+	database, err := NewSQLiteDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer, and every connection to :memory: is a
+	// brand-new database, so keep the whole pool on one connection
+	database.SetMaxOpenConns(1)
+	database.SetMaxIdleConns(1)
+	database.SetConnMaxLifetime(0)
+
+	dbConn.SQL = database
+	dbConn.Dialect = SQLite
+
+	err = testDB(database)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbConn, nil
+}
+
+// connect configures the pool limits and stores the pool in dbConn
+func connect(database *sql.DB, dialect string) (*DB, error) {
 	database.SetMaxOpenConns(maxOpenDbConn)
 	database.SetMaxIdleConns(maxIdleDbConn)
 	database.SetConnMaxLifetime(maxDbLifeTime)
 
 	dbConn.SQL = database
+	dbConn.Dialect = dialect
 
-	err = testDB(database)
+	err := testDB(database)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +100,43 @@ func testDB(d *sql.DB) error {
 
 // NewDatabase creates a new database using mySql for the application
 func NewDatabase(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+	return openDatabase("mysql", dsn)
+}
+
+// NewPostgresDatabase creates a new database using Postgres (pgx) for the application
+func NewPostgresDatabase(dsn string) (*sql.DB, error) {
+	return openDatabase("pgx", dsn)
+}
+
+// NewSQLiteDatabase creates a new database using SQLite (pure Go driver) for the application
+func NewSQLiteDatabase(dsn string) (*sql.DB, error) {
+	return openDatabase("sqlite", sqliteDSN(dsn))
+}
+
+// sqliteDSN makes the driver write times in a format that sorts lexically,
+// so date comparisons in queries behave like they do in MySQL and Postgres,
+// and enforce foreign keys, which SQLite leaves off unless asked
+func sqliteDSN(dsn string) string {
+	if !strings.Contains(dsn, "_time_format=") {
+		dsn = withParam(dsn, "_time_format=sqlite")
+	}
+	if !strings.Contains(dsn, "foreign_keys") {
+		dsn = withParam(dsn, "_pragma=foreign_keys(1)")
+	}
+	return dsn
+}
+
+// withParam adds a query parameter to a dsn
+func withParam(dsn, param string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
+	}
+	return dsn + "?" + param
+}
+
+// openDatabase opens and pings a database using the given sql driver
+func openDatabase(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
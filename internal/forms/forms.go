@@ -5,6 +5,7 @@ import (
 	"github.com/asaskevich/govalidator"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Form creates a custom form struct, embeds an url.Values object
@@ -61,3 +62,13 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsDate checks that the field holds a date in yyyy-mm-dd format
+func (f *Form) IsDate(field string) bool {
+	_, err := time.Parse("2006-01-02", f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "Invalid date, use yyyy-mm-dd")
+		return false
+	}
+	return true
+}
@@ -121,3 +121,20 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("shows email is invalid for valid email")
 	}
 }
+
+func TestForm_IsDate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start", "2050-01-31")
+	postedData.Add("end", "31/01/2050")
+	form := New(postedData)
+
+	if !form.IsDate("start") {
+		t.Error("shows date is invalid for valid date")
+	}
+	if form.IsDate("end") {
+		t.Error("shows date is valid for invalid date")
+	}
+	if form.Errors.Get("end") == "" {
+		t.Error("should have an error for end but did not get one")
+	}
+}
@@ -1,20 +1,32 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Seician/bookings/internal/config"
 	"github.com/Seician/bookings/internal/driver"
 	"github.com/Seician/bookings/internal/forms"
 	"github.com/Seician/bookings/internal/helpers"
+	"github.com/Seician/bookings/internal/ical"
+	"github.com/Seician/bookings/internal/icalsync"
+	"github.com/Seician/bookings/internal/invoices"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/payments"
+	"github.com/Seician/bookings/internal/pricing"
 	"github.com/Seician/bookings/internal/render"
 	"github.com/Seician/bookings/internal/repository"
 	"github.com/Seician/bookings/internal/repository/dbrepo"
-	"github.com/go-chi/chi"
-	"log"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,11 +39,24 @@ type Repository struct {
 	DB  repository.DatabaseRepo
 }
 
-// NewRepo creates a new repository
+// NewRepo creates a new repository for the dialect of the database pool
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
-	return &Repository{
-		App: a,
-		DB:  dbrepo.NewMySqlRepo(db.SQL, a),
+	switch db.Dialect {
+	case driver.Postgres:
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		}
+	case driver.SQLite:
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewSQLiteRepo(db.SQL, a),
+		}
+	default:
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewMySqlRepo(db.SQL, a),
+		}
 	}
 }
 
@@ -68,7 +93,7 @@ func (m *Repository) Reservation(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	room, err := m.DB.GetRoomById(reservation.RoomId)
+	room, err := m.DB.GetRoomById(request.Context(), reservation.RoomId)
 	if err != nil {
 		m.App.Session.Put(request.Context(), "error", "can't find room")
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
@@ -77,6 +102,15 @@ func (m *Repository) Reservation(writer http.ResponseWriter, request *http.Reque
 
 	reservation.Room.RoomName = room.RoomName
 
+	quote, err := m.quoteStay(request.Context(), room, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "can't price this stay")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	reservation.TotalPrice = quote.Total
+	reservation.Deposit = payments.Deposit(reservation.TotalPrice, m.App.Payments.DepositPercent)
+
 	m.App.Session.Put(request.Context(), "reservation", reservation)
 
 	sd := reservation.StartDate.Format("2006-01-02")
@@ -88,6 +122,7 @@ func (m *Repository) Reservation(writer http.ResponseWriter, request *http.Reque
 
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["quote"] = quote
 
 	render.Template(writer, request, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -149,64 +184,463 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
-	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-		http.Error(w, "my own error message", http.StatusSeeOther)
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+	// price the stay again rather than trusting what was shown on the form
+	room, err := m.DB.GetRoomById(r.Context(), reservation.RoomId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	reservation.Room.RoomName = room.RoomName
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	quote, err := m.quoteStay(r.Context(), room, reservation.StartDate, reservation.EndDate)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		m.App.Session.Put(r.Context(), "error", "can't price this stay")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	reservation.TotalPrice = quote.Total
+	reservation.Nights = quote.ReservationNights()
+
+	if form.Has("promo_code") {
+		err = m.applyPromoCode(r.Context(), form, &reservation, quote)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	reservation.Deposit = payments.Deposit(reservation.TotalPrice, m.App.Payments.DepositPercent)
+	if reservation.Deposit > 0 {
+		form.Required("payment_method")
+	}
+
+	if !form.Valid() {
+		m.renderInvalidReservation(w, r, reservation, quote, form)
+		return
+	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomId:        roomID,
-		ReservationId: int(newReservationID),
-		RestrictionId: 1,
+	reservation.Status = models.ReservationConfirmed
+	reservation.CancelToken, err = helpers.NewToken()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if reservation.Deposit > 0 {
+		payment, err := m.App.PaymentProvider.Authorize(r.Context(), payments.AuthorizeRequest{
+			Amount:        reservation.Deposit,
+			Currency:      "usd",
+			Description:   fmt.Sprintf("Deposit for the %s from %s to %s", reservation.Room.RoomName, sd, ed),
+			Email:         reservation.Email,
+			PaymentMethod: form.Get("payment_method"),
+			ReturnURL:     absoluteURL(r, "/make-reservation/payment"),
+		})
+		if errors.Is(err, payments.ErrDeclined) {
+			form.Errors.Add("payment_method", "Sorry, your card was declined")
+			m.renderInvalidReservation(w, r, reservation, quote, form)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		reservation.PaymentId = payment.ID
+		reservation.PaymentStatus = payment.Status
+
+		// the card needs a 3-D Secure check, ReservationPayment books the room once it's done
+		if payment.Status == payments.StatusRequiresAction {
+			m.App.Session.Put(r.Context(), "pending_reservation", reservation)
+			http.Redirect(w, r, payment.RedirectURL, http.StatusSeeOther)
+			return
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	err = m.bookReservation(r.Context(), &reservation)
+	if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		form.Errors.Add("promo_code", "Sorry, this code has just been used up")
+		m.renderInvalidReservation(w, r, reservation, quote, form)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction!")
+		m.reservationFailed(w, r, err)
+		return
+	}
+
+	m.confirmReservation(w, r, reservation)
+}
+
+// ReservationPayment is where the guest comes back to after the 3-D Secure check of their deposit,
+// and books the reservation that was waiting for it
+func (m *Repository) ReservationPayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "pending_reservation").(models.Reservation)
+	if !ok || reservation.PaymentId != r.URL.Query().Get("payment_id") {
+		m.App.Session.Put(r.Context(), "error", "can't find the reservation for this payment")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	htmlMessage := fmt.Sprintf(`
-  <h1> Reservation confirmation </h1>
-`)
+	m.App.Session.Remove(r.Context(), "pending_reservation")
+
+	// nothing is booked unless the check passed and the deposit is held
+	payment, err := m.App.PaymentProvider.Get(r.Context(), reservation.PaymentId)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if payment.Status != payments.StatusAuthorized {
+		m.voidDeposit(r.Context(), reservation)
+		m.App.Session.Put(r.Context(), "error", "Sorry, your card was declined")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	reservation.PaymentStatus = payment.Status
+
+	err = m.bookReservation(r.Context(), &reservation)
+	if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, your promo code has just been used up")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.reservationFailed(w, r, err)
+		return
+	}
+
+	m.confirmReservation(w, r, reservation)
+}
+
+// bookReservation creates the reservation and then takes the deposit authorized for it, if any, so
+// a guest is never charged for a room that was taken in the meantime. The authorization is voided
+// when the reservation can't be created, and the reservation cancelled when the deposit can't be taken
+func (m *Repository) bookReservation(ctx context.Context, reservation *models.Reservation) error {
+	newReservationID, err := m.DB.CreateReservation(ctx, *reservation)
+	if err != nil {
+		m.voidDeposit(ctx, *reservation)
+		return err
+	}
+	reservation.ID = newReservationID
+
+	if reservation.PaymentId != "" {
+		payment, err := m.App.PaymentProvider.Capture(ctx, reservation.PaymentId)
+		if err != nil {
+			cancelErr := m.DB.CancelReservation(ctx, reservation.ID)
+			if cancelErr != nil {
+				m.App.Logger.ErrorContext(ctx, "can't cancel the reservation whose deposit failed", "reservation_id", reservation.ID, "error", cancelErr)
+			}
+			m.voidDeposit(ctx, *reservation)
+			return err
+		}
+		reservation.PaymentStatus = payment.Status
+
+		err = m.DB.UpdatePaymentStatus(ctx, reservation.PaymentId, payment.Status)
+		if err != nil {
+			m.App.Logger.ErrorContext(ctx, "can't record the deposit", "reservation_id", reservation.ID, "error", err)
+		}
+	}
+
+	m.App.Metrics.ReservationsCreated.Inc()
+	return nil
+}
+
+// voidDeposit releases the deposit held for a reservation that didn't go ahead
+func (m *Repository) voidDeposit(ctx context.Context, reservation models.Reservation) {
+	if reservation.PaymentId == "" {
+		return
+	}
+	// a payment that was declined holds nothing to void
+	_, err := m.App.PaymentProvider.Void(ctx, reservation.PaymentId)
+	if err != nil && !errors.Is(err, payments.ErrInvalidState) {
+		m.App.Logger.ErrorContext(ctx, "can't void the deposit", "payment_id", reservation.PaymentId, "error", err)
+	}
+}
+
+// releaseDeposit gives back the deposit of a reservation cancelled by the owner: a deposit that is
+// only held is voided, one that was taken is refunded in full
+func (m *Repository) releaseDeposit(ctx context.Context, reservation models.Reservation) {
+	if reservation.PaymentId == "" {
+		return
+	}
+
+	var payment payments.Payment
+	var err error
+	switch reservation.PaymentStatus {
+	case payments.StatusAuthorized:
+		payment, err = m.App.PaymentProvider.Void(ctx, reservation.PaymentId)
+	case payments.StatusCaptured:
+		payment, err = m.App.PaymentProvider.Refund(ctx, reservation.PaymentId, 0)
+	default:
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't give back the deposit", "payment_id", reservation.PaymentId, "error", err)
+		return
+	}
+
+	err = m.DB.UpdatePaymentStatus(ctx, reservation.PaymentId, payment.Status)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't record the returned deposit", "payment_id", reservation.PaymentId, "error", err)
+	}
+}
+
+// reservationFailed tells the guest why their reservation couldn't be created
+func (m *Repository) reservationFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was just booked for some of those dates. Please choose different dates.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrRoomInactive) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room isn't taking bookings. Please choose another room.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// confirmReservation mails the guest and the owner about a new reservation and shows the guest its summary
+func (m *Repository) confirmReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation) {
+	data := m.reservationMailData(reservation)
+	data.ManageURL = absoluteURL(r, "/cancel-reservation/"+reservation.CancelToken)
+	data.AdminURL = absoluteURL(r, fmt.Sprintf("/admin/reservations/new/%d", reservation.ID))
+
+	// the room is booked either way, so an invoice that can't be issued doesn't stop the mails.
+	// The guest is told it will follow and the owner that it has to be sent from the admin area
+	inv, pdf, err := m.invoicePDF(r.Context(), reservation)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't issue the invoice", "reservation_id", reservation.ID, "error", err)
+		data.InvoiceMissing = true
+	}
+
+	// send notifications - first to guest. A mail that can't be rendered is only logged
+	msg, err := m.mailFromTemplate(reservation.Email, "Reservation confirmation", "confirmation", data)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't render the confirmation", "reservation_id", reservation.ID, "error", err)
+	} else {
+		if !data.InvoiceMissing {
+			msg.Attachments = append(msg.Attachments, models.MailAttachment{
+				Name:        invoices.Filename(inv),
+				ContentType: "application/pdf",
+				Data:        pdf,
+			})
+		}
+
+		ics, err := m.stayCalendar(r, reservation, data.ManageURL)
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "can't attach the calendar event", "reservation_id", reservation.ID, "error", err)
+		} else {
+			msg.Attachments = append(msg.Attachments, models.MailAttachment{
+				Name:        "reservation.ics",
+				ContentType: ical.ContentType + "; method=PUBLISH",
+				Data:        ics,
+			})
+		}
+		m.queueMail(r.Context(), msg)
+	}
 
-	// send notifications - first to guest
-	msg := models.MailData{
-		To:      reservation.Email,
-		From:    "me@yahoo.com",
-		Subject: "Reservation confirmation",
-		Content: htmlMessage,
+	// then to the owner
+	subject := fmt.Sprintf("New reservation: %s, %s to %s", reservation.Room.RoomName,
+		reservation.StartDate.Format("Jan 2"), reservation.EndDate.Format("Jan 2, 2006"))
+	msg, err = m.mailFromTemplate(m.App.Property.Email, subject, "owner-notification", data)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't render the owner notification", "reservation_id", reservation.ID, "error", err)
+	} else {
+		m.queueMail(r.Context(), msg)
 	}
-	m.App.MailChan <- msg
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(writer http.ResponseWriter, request *http.Request) {
-	render.Template(writer, request, "generals.page.tmpl", &models.TemplateData{})
+// PaymentWebhook records the payment events the payment provider sends against the reservations
+// they belong to
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	event, err := m.App.PaymentProvider.VerifyWebhook(payload, r.Header.Get(payments.SignatureHeader))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	switch event.Type {
+	case payments.EventCaptured:
+		status = payments.StatusCaptured
+	case payments.EventDeclined:
+		status = payments.StatusDeclined
+	case payments.EventRefunded:
+		status = payments.StatusRefunded
+	default:
+		// nothing to record, but the provider mustn't send it again
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// payments that never turned into a reservation have nothing to update
+	err = m.DB.UpdatePaymentStatus(r.Context(), event.PaymentID, status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// renderInvalidReservation shows the reservation form again with the errors on form
+func (m *Repository) renderInvalidReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation, quote pricing.Quote, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["quote"] = quote
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+
+	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// applyPromoCode takes the discount of the promo code entered on form off the reservation, or adds
+// a form error when the code can't be used for this stay
+func (m *Repository) applyPromoCode(ctx context.Context, form *forms.Form, reservation *models.Reservation, quote pricing.Quote) error {
+	code, err := m.DB.GetPromoCodeByCode(ctx, strings.TrimSpace(form.Get("promo_code")))
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("promo_code", "Sorry, we don't recognise this code")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	discount, err := pricing.Discount(code, reservation.RoomId, quote)
+	if err != nil {
+		form.Errors.Add("promo_code", "Sorry, "+err.Error())
+		return nil
+	}
+
+	reservation.PromoCodeId = code.ID
+	reservation.PromoCode = code.Code
+	reservation.Discount = discount
+	reservation.TotalPrice = quote.Total - discount
+	return nil
+}
+
+// quoteStay prices a stay in room, applying the room's seasonal rates for those dates
+func (m *Repository) quoteStay(ctx context.Context, room models.Room, start, end time.Time) (pricing.Quote, error) {
+	seasons, err := m.DB.GetRoomRatesByDate(ctx, room.ID, start, end)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	return pricing.Calculate(room, seasons, start, end)
+}
+
+// Room renders the page of the room at /rooms/{slug}
+func (m *Repository) Room(writer http.ResponseWriter, request *http.Request) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 3 || exploded[2] == "" {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomBySlug(request.Context(), exploded[2])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(writer, request, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// RoomCalendarFeed serves the reservations and blocks of a room at /ical/{slug}.ics, for calendar
+// apps and other booking channels to subscribe to. The link is signed with the feed secret, and
+// events only say a room is taken, never by whom
+func (m *Repository) RoomCalendarFeed(writer http.ResponseWriter, request *http.Request) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 3 || !strings.HasSuffix(exploded[2], ".ics") || m.App.ICal.FeedSecret == "" {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomBySlug(request.Context(), strings.TrimSuffix(exploded[2], ".ics"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	if !ical.ValidFeedToken(m.App.ICal.FeedSecret, room.ID, request.URL.Query().Get("token")) {
+		helpers.ClientError(writer, request, http.StatusForbidden)
+		return
+	}
+
+	// recent stays stay visible for a while, and nothing is booked more than two years out
+	today := time.Now().Truncate(24 * time.Hour)
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(request.Context(), room.ID, today.AddDate(0, 0, -90), today.AddDate(2, 0, 0))
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	cal := ical.Calendar{Name: m.App.Property.Name + " - " + room.RoomName}
+	for _, rr := range restrictions {
+		// bookings imported from other channels are theirs to publish, not ours
+		if rr.RestrictionId == models.RestrictionExternal {
+			continue
+		}
+		e := ical.Event{
+			UID:     ical.UID("restriction", rr.ID, m.App.Property.Domain),
+			Summary: rr.Restriction.RestrictionName,
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+		}
+		if rr.ReservationId > 0 {
+			e.UID = ical.UID("reservation", rr.ReservationId, m.App.Property.Domain)
+			e.Summary = "Reserved"
+		}
+		cal.Events = append(cal.Events, e)
+	}
+
+	writer.Header().Set("Content-Type", ical.ContentType)
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", room.Slug+".ics"))
+	err = cal.Write(writer)
+	if err != nil {
+		m.App.Logger.ErrorContext(request.Context(), "can't write the room calendar", "room_id", room.ID, "error", err)
+	}
+}
+
+// roomFeedURL returns the signed link of the calendar feed of a room, or "" when feeds are off
+func (m *Repository) roomFeedURL(request *http.Request, room models.Room) string {
+	if m.App.ICal.FeedSecret == "" || room.Slug == "" {
+		return ""
+	}
+	return absoluteURL(request, fmt.Sprintf("/ical/%s.ics?token=%s", room.Slug, ical.FeedToken(m.App.ICal.FeedSecret, room.ID)))
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(writer http.ResponseWriter, request *http.Request) {
-	render.Template(writer, request, "majors.page.tmpl", &models.TemplateData{})
+// RedirectToRoom sends the old room urls, like /generals-quarters, to the room's page
+func (m *Repository) RedirectToRoom(writer http.ResponseWriter, request *http.Request) {
+	http.Redirect(writer, request, "/rooms"+request.URL.Path, http.StatusMovedPermanently)
 }
 
 // Availability renders the search availability page
@@ -216,6 +650,12 @@ func (m *Repository) Availability(writer http.ResponseWriter, request *http.Requ
 
 // PostAvailability renders the search availability page
 func (m *Repository) PostAvailability(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "can't parse form!")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
 	start := request.Form.Get("start")
 	end := request.Form.Get("end")
@@ -233,22 +673,35 @@ func (m *Repository) PostAvailability(writer http.ResponseWriter, request *http.
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(request.Context(), startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(request.Context(), "error", "can't get availability for rooms")
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	m.App.Metrics.AvailabilitySearches.Inc()
 	if len(rooms) == 0 {
 		// no availability
+		m.App.Metrics.NoAvailability.Inc()
 		m.App.Session.Put(request.Context(), "error", "No availability")
 		http.Redirect(writer, request, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
+	quotes := make(map[int]pricing.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.quoteStay(request.Context(), room, startDate, endDate)
+		if err != nil {
+			m.App.Session.Put(request.Context(), "error", "can't price rooms for those dates")
+			http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 
 	data["rooms"] = rooms
+	data["quotes"] = quotes
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -279,7 +732,7 @@ func (m *Repository) AvailabilityJSON(writer http.ResponseWriter, request *http.
 		// can't parse form, so return appropriate json
 		resp := jsonResponse{
 			OK:      false,
-			Message: "Internal Server Error",
+			Message: "Internal server error",
 		}
 
 		out, _ := json.MarshalIndent(resp, "", "      ")
@@ -296,7 +749,7 @@ func (m *Repository) AvailabilityJSON(writer http.ResponseWriter, request *http.
 
 	roomID, _ := strconv.Atoi(request.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomId(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomId(request.Context(), startDate, endDate, roomID)
 	if err != nil {
 		// got a database error, so return appropriate json
 		resp := jsonResponse{
@@ -309,6 +762,10 @@ func (m *Repository) AvailabilityJSON(writer http.ResponseWriter, request *http.
 		writer.Write(out)
 		return
 	}
+	m.App.Metrics.AvailabilitySearches.Inc()
+	if !available {
+		m.App.Metrics.NoAvailability.Inc()
+	}
 	response := jsonResponse{
 		OK:        available,
 		Message:   "",
@@ -333,7 +790,7 @@ func (m *Repository) ReservationSummary(writer http.ResponseWriter, request *htt
 	reservation, ok := m.App.Session.Get(request.Context(), "reservation").(models.Reservation)
 
 	if !ok {
-		m.App.ErrorLog.Println("Can't get error from session")
+		m.App.Logger.ErrorContext(request.Context(), "can't get reservation from session")
 		m.App.Session.Put(request.Context(), "error", "Can't get reservation from session")
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 
@@ -345,7 +802,7 @@ func (m *Repository) ReservationSummary(writer http.ResponseWriter, request *htt
 	data["reservation"] = reservation
 
 	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.StartDate.Format("2006-01-02")
+	ed := reservation.EndDate.Format("2006-01-02")
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
@@ -357,15 +814,31 @@ func (m *Repository) ReservationSummary(writer http.ResponseWriter, request *htt
 
 // ChooseRoom display list of available rooms
 func (m *Repository) ChooseRoom(writer http.ResponseWriter, request *http.Request) {
-	roomId, err := strconv.Atoi(chi.URLParam(request, "id"))
+	// split the URL up by /, and grab the 3rd element
+	exploded := strings.Split(request.RequestURI, "/")
+	roomId, err := strconv.Atoi(exploded[2])
 	if err != nil {
-		helpers.ServerError(writer, err)
+		m.App.Session.Put(request.Context(), "error", "missing url parameter")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
 	res, ok := m.App.Session.Get(request.Context(), "reservation").(models.Reservation)
 	if !ok {
-		helpers.ServerError(writer, err)
+		m.App.Session.Put(request.Context(), "error", "Can't get reservation from session")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(request.Context(), roomId)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "Can't get room from db!")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if !room.Active {
+		m.App.Session.Put(request.Context(), "error", "Sorry, this room isn't taking bookings")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
@@ -390,12 +863,17 @@ func (m *Repository) BookRoom(writer http.ResponseWriter, request *http.Request)
 
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomById(roomID)
+	room, err := m.DB.GetRoomById(request.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(request.Context(), "error", "Can't get room from db!")
 		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if !room.Active {
+		m.App.Session.Put(request.Context(), "error", "Sorry, this room isn't taking bookings")
+		http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
 	res.Room.RoomName = room.RoomName
 
@@ -419,7 +897,7 @@ func (m *Repository) PostShowLogin(writer http.ResponseWriter, request *http.Req
 
 	err := request.ParseForm()
 	if err != nil {
-		log.Print(err)
+		m.App.Logger.InfoContext(request.Context(), "can't parse the login form", "error", err)
 	}
 
 	email := request.Form.Get("email")
@@ -436,9 +914,9 @@ func (m *Repository) PostShowLogin(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(request.Context(), email, password)
 	if err != nil {
-		log.Print(err)
+		m.App.Logger.InfoContext(request.Context(), "login failed", "error", err)
 
 		m.App.Session.Put(request.Context(), "error", "Invalid login credentials")
 		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
@@ -461,3 +939,1479 @@ func (m *Repository) Logout(writer http.ResponseWriter, request *http.Request) {
 func (m *Repository) AdminDashboard(writer http.ResponseWriter, request *http.Request) {
 	render.Template(writer, request, "admin-dashboard.page.tmpl", &models.TemplateData{})
 }
+
+// AdminNewReservations shows reservations that staff have not processed yet
+func (m *Repository) AdminNewReservations(writer http.ResponseWriter, request *http.Request) {
+	m.adminReservations(writer, request, "new")
+}
+
+// AdminAllReservations shows all reservations
+func (m *Repository) AdminAllReservations(writer http.ResponseWriter, request *http.Request) {
+	m.adminReservations(writer, request, "all")
+}
+
+// adminReservations renders the filtered, sorted and paginated reservations list
+func (m *Repository) adminReservations(writer http.ResponseWriter, request *http.Request, src string) {
+	query := request.URL.Query()
+	layout := "2006-01-02"
+
+	filter := models.ReservationFilter{
+		Status:     query.Get("status"),
+		Guest:      query.Get("q"),
+		Sort:       query.Get("sort"),
+		Descending: query.Get("dir") == "desc",
+		PerPage:    20,
+	}
+	if src == "new" {
+		filter.Status = "new"
+	}
+	filter.From, _ = time.Parse(layout, query.Get("from"))
+	filter.To, _ = time.Parse(layout, query.Get("to"))
+	filter.RoomId, _ = strconv.Atoi(query.Get("room"))
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+
+	reservations, total, err := m.DB.ListReservations(request.Context(), filter)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	// query string for the pagination links, without the page itself
+	query.Del("page")
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["rooms"] = rooms
+	data["query"] = template.URL(query.Encode())
+	data["statuses"] = models.ReservationStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["from"] = query.Get("from")
+	stringMap["to"] = query.Get("to")
+	stringMap["status"] = filter.Status
+	stringMap["q"] = filter.Guest
+	stringMap["sort"] = filter.Sort
+	stringMap["dir"] = query.Get("dir")
+
+	intMap := make(map[string]int)
+	intMap["room"] = filter.RoomId
+	intMap["page"] = filter.Page
+	intMap["pages"] = (total + filter.PerPage - 1) / filter.PerPage
+	intMap["total"] = total
+
+	render.Template(writer, request, "admin-reservations.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// reservationPath returns the list a reservation page was opened from and the reservation id,
+// from urls like /admin/reservations/{src}/{id}
+func reservationPath(request *http.Request) (string, int, error) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 5 {
+		return "", 0, errors.New("missing url parameter")
+	}
+
+	src := exploded[3]
+	if src != "new" {
+		src = "all"
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		return "", 0, err
+	}
+	return src, id, nil
+}
+
+// AdminShowReservation shows a reservation in the admin tool
+func (m *Repository) AdminShowReservation(writer http.ResponseWriter, request *http.Request) {
+	src, id, err := reservationPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.renderAdminReservation(writer, request, src, res, forms.New(nil))
+}
+
+// AdminReservationInvoice downloads the PDF invoice of a reservation, issuing it the first time
+func (m *Repository) AdminReservationInvoice(writer http.ResponseWriter, request *http.Request) {
+	_, id, err := reservationPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	inv, pdf, err := m.invoicePDF(request.Context(), res)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/pdf")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", invoices.Filename(inv)))
+	_, _ = writer.Write(pdf)
+}
+
+// invoicePDF prints the invoice of res, issuing it the next invoice number the first time. It's
+// printed as it was issued, whatever has changed about res since
+func (m *Repository) invoicePDF(ctx context.Context, res models.Reservation) (models.Invoice, []byte, error) {
+	room, err := m.DB.GetRoomById(ctx, res.RoomId)
+	if err != nil {
+		return models.Invoice{}, nil, err
+	}
+	res.Room.RoomName = room.RoomName
+
+	inv, err := m.DB.InvoiceForReservation(ctx, invoices.Draft(res, m.App.Property.TaxPercent))
+	if err != nil {
+		return inv, nil, err
+	}
+
+	var buf bytes.Buffer
+	err = invoices.Write(&buf, invoices.Document{
+		Property:    m.App.Property,
+		Invoice:     inv,
+		Reservation: res,
+	})
+	if err != nil {
+		return inv, nil, err
+	}
+	return inv, buf.Bytes(), nil
+}
+
+// AdminPostShowReservation saves the changes made to a reservation in the admin tool
+func (m *Repository) AdminPostShowReservation(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	src, id, err := reservationPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date", "room_id")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	// the stay keeps the price it was booked at unless it moves
+	booked := res
+
+	res.FirstName = form.Get("first_name")
+	res.LastName = form.Get("last_name")
+	res.Email = form.Get("email")
+	res.Phone = form.Get("phone")
+
+	layout := "2006-01-02"
+	if form.IsDate("start_date") {
+		res.StartDate, _ = time.Parse(layout, form.Get("start_date"))
+	}
+	if form.IsDate("end_date") {
+		res.EndDate, _ = time.Parse(layout, form.Get("end_date"))
+		if !res.EndDate.After(res.StartDate) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	} else {
+		res.RoomId = roomID
+	}
+
+	if !form.Valid() {
+		m.renderAdminReservation(writer, request, src, res, form)
+		return
+	}
+
+	if res.RoomId != booked.RoomId || !res.StartDate.Equal(booked.StartDate) || !res.EndDate.Equal(booked.EndDate) {
+		room, err := m.DB.GetRoomById(request.Context(), res.RoomId)
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+
+		quote, err := m.quoteStay(request.Context(), room, res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		res.Nights = quote.ReservationNights()
+		// a promo code keeps the discount it gave when the guest booked
+		res.TotalPrice = quote.Total - res.Discount
+		if res.TotalPrice < 0 {
+			res.TotalPrice = 0
+		}
+	}
+
+	err = m.DB.UpdateReservation(request.Context(), res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(request.Context(), "error", "That room is not available for those dates")
+		m.renderAdminReservation(writer, request, src, res, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Changes saved")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(writer http.ResponseWriter, request *http.Request) {
+	src, id, err := reservationPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.UpdateProcessedForReservation(request.Context(), id, 1)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Reservation marked as processed")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// renderAdminReservation renders the reservation edit form
+func (m *Repository) renderAdminReservation(writer http.ResponseWriter, request *http.Request, src string, res models.Reservation, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["rooms"] = rooms
+	// the current status followed by those the reservation may move to
+	data["statuses"] = append([]string{res.Status}, models.ReservationTransitions[res.Status]...)
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	render.Template(writer, request, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// ShowCancelReservation shows a guest the reservation behind their cancellation link
+func (m *Repository) ShowCancelReservation(writer http.ResponseWriter, request *http.Request) {
+	res, ok := m.reservationFromToken(writer, request)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	render.Template(writer, request, "cancel-reservation.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// PostCancelReservation cancels a reservation on behalf of the guest
+func (m *Repository) PostCancelReservation(writer http.ResponseWriter, request *http.Request) {
+	res, ok := m.reservationFromToken(writer, request)
+	if !ok {
+		return
+	}
+
+	err := m.DB.CancelReservation(request.Context(), res.ID)
+	if errors.Is(err, repository.ErrNotCancellable) {
+		m.App.Session.Put(request.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.sendCancellationMail(request.Context(), res)
+
+	m.App.Session.Put(request.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+// reservationFromToken loads the reservation for /cancel-reservation/{token}, answering
+// with not found when there isn't one
+func (m *Repository) reservationFromToken(writer http.ResponseWriter, request *http.Request) (models.Reservation, bool) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 3 || exploded[2] == "" {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByToken(request.Context(), exploded[2])
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return res, false
+	}
+	return res, true
+}
+
+// AdminPostReservationStatus changes the status of a reservation, cancelling it when asked to
+func (m *Repository) AdminPostReservationStatus(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	src, id, err := reservationPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	status := request.Form.Get("status")
+	valid := false
+	for _, s := range models.ReservationStatuses {
+		if s == status {
+			valid = true
+		}
+	}
+	if !valid {
+		helpers.ClientError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+
+	err = m.DB.UpdateReservationStatus(request.Context(), id, status)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("Can't change a %s reservation to %s", res.Status, status))
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	if status == models.ReservationCancelled {
+		m.releaseDeposit(request.Context(), res)
+		m.sendCancellationMail(request.Context(), res)
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Reservation is now "+status)
+	http.Redirect(writer, request, back, http.StatusSeeOther)
+}
+
+// stayCalendar returns an .ics file with the stay of a reservation, for the guest's calendar
+func (m *Repository) stayCalendar(request *http.Request, res models.Reservation, manageURL string) ([]byte, error) {
+	cal := ical.Calendar{
+		Method: "PUBLISH",
+		Events: []ical.Event{{
+			UID:         ical.UID("reservation", res.ID, m.App.Property.Domain),
+			Summary:     "Stay at " + m.App.Property.Name,
+			Description: fmt.Sprintf("%s, reservation %d. Manage your booking at %s", res.Room.RoomName, res.ID, manageURL),
+			Location:    m.App.Property.Address,
+			URL:         manageURL,
+			Start:       res.StartDate,
+			End:         res.EndDate,
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := cal.Write(&buf)
+	return buf.Bytes(), err
+}
+
+// sendCancellationMail lets the guest know their reservation was cancelled
+func (m *Repository) sendCancellationMail(ctx context.Context, res models.Reservation) {
+	msg, err := m.mailFromTemplate(res.Email, "Reservation cancelled", "cancellation", m.reservationMailData(res))
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't render the cancellation", "reservation_id", res.ID, "error", err)
+		return
+	}
+	m.queueMail(ctx, msg)
+}
+
+// queueMail adds an email to the outbox. The request it is sent from goes ahead if that fails
+func (m *Repository) queueMail(ctx context.Context, msg models.MailData) {
+	err := m.App.MailQueue.Enqueue(ctx, msg)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't queue mail", "subject", msg.Subject, "error", err)
+	}
+}
+
+// reservationMail is what the reservation email templates are rendered with. InvoiceMissing is
+// set when the invoice of a new reservation couldn't be issued to go with its confirmation
+type reservationMail struct {
+	Property       config.PropertyConfig
+	Reservation    models.Reservation
+	Nights         int
+	ManageURL      string
+	AdminURL       string
+	InvoiceMissing bool
+}
+
+// reservationMailData fills in the parts of a reservation email that don't depend on the request
+func (m *Repository) reservationMailData(res models.Reservation) reservationMail {
+	return reservationMail{
+		Property:    m.App.Property,
+		Reservation: res,
+		Nights:      int(res.EndDate.Sub(res.StartDate).Hours() / 24),
+	}
+}
+
+// mailFromTemplate renders the email template name with data into a message to to, from the site's sender
+func (m *Repository) mailFromTemplate(to, subject, name string, data interface{}) (models.MailData, error) {
+	html, text, err := render.Mail(name, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:           to,
+		From:         m.App.SMTP.From,
+		Subject:      subject,
+		Content:      html,
+		PlainContent: text,
+	}, nil
+}
+
+// absoluteURL turns a path into a link for emails, on the host the request came in on
+func absoluteURL(request *http.Request, path string) string {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, request.Host, path)
+}
+
+// AdminReservationsCalendar shows every room against the days of a month, with its
+// reservations and blocks
+func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
+	now := time.Now()
+	year, err := strconv.Atoi(request.URL.Query().Get("y"))
+	if err != nil {
+		year = now.Year()
+	}
+	month, err := strconv.Atoi(request.URL.Query().Get("m"))
+	if err != nil || month < 1 || month > 12 {
+		month = int(now.Month())
+	}
+
+	first := render.MonthStart(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC))
+	days := render.MonthDays(first)
+	next := first.AddDate(0, 1, 0)
+	last := first.AddDate(0, -1, 0)
+
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	var rows []render.CalendarRow
+	for _, room := range rooms {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(request.Context(), room.ID, first, next)
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		rows = append(rows, render.CalendarRowFor(room, days, restrictions))
+	}
+
+	data := make(map[string]interface{})
+	data["days"] = days
+	data["rows"] = rows
+
+	stringMap := make(map[string]string)
+	stringMap["this_month"] = first.Format("January 2006")
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+
+	render.Template(writer, request, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminBlocks lists the upcoming owner blocks, maintenance and closures, with a form to add one
+func (m *Repository) AdminBlocks(writer http.ResponseWriter, request *http.Request) {
+	m.renderAdminBlocks(writer, request, forms.New(nil))
+}
+
+// AdminPostBlock closes a room for a range of days. The last day is inclusive on the form and
+// stored as the day after, the way a reservation stores its departure day
+func (m *Repository) AdminPostBlock(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("room_id", "restriction_id", "start_date", "end_date")
+
+	var block models.RoomRestriction
+	layout := "2006-01-02"
+
+	if form.IsDate("start_date") {
+		block.StartDate, _ = time.Parse(layout, form.Get("start_date"))
+	}
+	if form.IsDate("end_date") {
+		lastDay, _ := time.Parse(layout, form.Get("end_date"))
+		if lastDay.Before(block.StartDate) {
+			form.Errors.Add("end_date", "Last day can't be before the first day")
+		}
+		block.EndDate = lastDay.AddDate(0, 0, 1)
+	}
+
+	block.RoomId, err = strconv.Atoi(form.Get("room_id"))
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	block.RestrictionId, err = strconv.Atoi(form.Get("restriction_id"))
+	if err != nil || block.RestrictionId == models.RestrictionReservation || block.RestrictionId == models.RestrictionExternal {
+		form.Errors.Add("restriction_id", "Choose the kind of block")
+	}
+
+	if !form.Valid() {
+		m.renderAdminBlocks(writer, request, form)
+		return
+	}
+
+	err = m.DB.CreateBlock(request.Context(), block)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(request.Context(), "error", "The room is already reserved or blocked on some of those days")
+		m.renderAdminBlocks(writer, request, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Block added")
+	http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+}
+
+// AdminDeleteBlock removes a block from /admin/blocks/{id}/delete, opening the room again
+func (m *Repository) AdminDeleteBlock(writer http.ResponseWriter, request *http.Request) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 4 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteBlockByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Block removed")
+	http.Redirect(writer, request, "/admin/blocks", http.StatusSeeOther)
+}
+
+// renderAdminBlocks renders the blocks page with the form to add one
+func (m *Repository) renderAdminBlocks(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	today := time.Now().Truncate(24 * time.Hour)
+
+	blocks, err := m.DB.ListBlocks(request.Context(), today)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	restrictions, err := m.DB.AllRestrictions(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	// reservations are made by guests and external bookings imported from other channels, not from here
+	var kinds []models.Restriction
+	for _, r := range restrictions {
+		if r.ID != models.RestrictionReservation && r.ID != models.RestrictionExternal {
+			kinds = append(kinds, r)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["blocks"] = blocks
+	data["rooms"] = rooms
+	data["restrictions"] = kinds
+
+	render.Template(writer, request, "admin-blocks.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminRooms lists all rooms, active or not
+func (m *Repository) AdminRooms(writer http.ResponseWriter, request *http.Request) {
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(writer, request, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// adminPathID returns the id from admin urls like /admin/rooms/{id} or /admin/promo-codes/{id},
+// and 0 for /admin/rooms/new and the like
+func adminPathID(request *http.Request) (int, error) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing url parameter")
+	}
+	if exploded[3] == "new" {
+		return 0, nil
+	}
+	return strconv.Atoi(exploded[3])
+}
+
+// AdminShowRoom shows the form to edit a room, or to add one
+func (m *Repository) AdminShowRoom(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	room := models.Room{Capacity: 2, Active: true}
+	if id > 0 {
+		room, err = m.DB.GetRoomById(request.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(writer, request, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+	}
+
+	m.renderAdminRoom(writer, request, room, forms.New(nil))
+}
+
+// AdminPostRoom saves a room, adding it when it is new
+func (m *Repository) AdminPostRoom(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	id, err := adminPathID(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("room_name", "capacity")
+	form.MinLength("room_name", 3)
+
+	room := models.Room{
+		ID:          id,
+		RoomName:    form.Get("room_name"),
+		Slug:        strings.TrimSpace(form.Get("slug")),
+		Description: form.Get("description"),
+		Amenities:   lines(form.Get("amenities")),
+		Active:      form.Has("active"),
+	}
+	for _, fileName := range lines(form.Get("photos")) {
+		room.Photos = append(room.Photos, models.RoomPhoto{FileName: fileName})
+	}
+
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+	}
+	if !slugPattern.MatchString(room.Slug) {
+		form.Errors.Add("slug", "Use lower case letters, digits and dashes only")
+	} else {
+		other, err := m.DB.GetRoomBySlug(request.Context(), room.Slug)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		if err == nil && other.ID != room.ID {
+			form.Errors.Add("slug", "Another room already uses this address")
+		}
+	}
+
+	room.Capacity, err = strconv.Atoi(form.Get("capacity"))
+	if err != nil || room.Capacity < 1 {
+		form.Errors.Add("capacity", "Capacity must be a number of guests")
+	}
+
+	room.BaseRate, err = parseMoney(form.Get("base_rate"))
+	if err != nil || room.BaseRate <= 0 {
+		form.Errors.Add("base_rate", "Enter the nightly rate, like 99.00")
+	}
+	room.WeekendRate, err = parseMoney(form.Get("weekend_rate"))
+	if err != nil {
+		form.Errors.Add("weekend_rate", "Enter the weekend rate, like 129.00, or leave it blank")
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(writer, request, room, form)
+		return
+	}
+
+	if id == 0 {
+		_, err = m.DB.InsertRoom(request.Context(), room)
+	} else {
+		err = m.DB.UpdateRoom(request.Context(), room)
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room saved")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room from /admin/rooms/{id}/delete, unless it has been booked
+func (m *Repository) AdminDeleteRoom(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRoom(request.Context(), id)
+	if errors.Is(err, repository.ErrRoomHasReservations) {
+		m.App.Session.Put(request.Context(), "error", "This room has reservations, so it can't be deleted. Deactivate it instead to stop taking bookings.")
+		http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room deleted")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomRate adds a seasonal rate to a room from /admin/rooms/{id}/rates
+func (m *Repository) AdminPostRoomRate(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("season_name", "season_start", "season_end", "season_nightly_rate")
+
+	rate := models.RoomRate{
+		RoomId: id,
+		Name:   form.Get("season_name"),
+	}
+
+	layout := "2006-01-02"
+	if form.IsDate("season_start") {
+		rate.StartDate, _ = time.Parse(layout, form.Get("season_start"))
+	}
+	if form.IsDate("season_end") {
+		rate.EndDate, _ = time.Parse(layout, form.Get("season_end"))
+		if rate.EndDate.Before(rate.StartDate) {
+			form.Errors.Add("season_end", "The last night can't be before the first")
+		}
+	}
+
+	rate.NightlyRate, err = parseMoney(form.Get("season_nightly_rate"))
+	if err != nil || rate.NightlyRate <= 0 {
+		form.Errors.Add("season_nightly_rate", "Enter the nightly rate, like 99.00")
+	}
+	rate.WeekendRate, err = parseMoney(form.Get("season_weekend_rate"))
+	if err != nil {
+		form.Errors.Add("season_weekend_rate", "Enter the weekend rate, like 129.00, or leave it blank")
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(writer, request, room, form)
+		return
+	}
+
+	err = m.DB.InsertRoomRate(request.Context(), rate)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Seasonal rate added")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomRate removes a seasonal rate from /admin/rooms/{id}/rates/{rate}/delete
+func (m *Repository) AdminDeleteRoomRate(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 6 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	rateID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRoomRate(request.Context(), id, rateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Seasonal rate removed")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminPostRoomCalendar adds the calendar of a room on another booking channel, at /admin/rooms/{id}/calendars,
+// for the sync job to import blocks from
+func (m *Repository) AdminPostRoomCalendar(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("calendar_name", "calendar_url")
+
+	cal := models.RoomCalendar{
+		RoomId: id,
+		Name:   form.Get("calendar_name"),
+	}
+
+	if form.Has("calendar_url") {
+		cal.URL, err = calendarURL(form.Get("calendar_url"))
+		if err != nil {
+			form.Errors.Add("calendar_url", "Enter the iCal link the other channel gives you, starting with https://")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(writer, request, room, form)
+		return
+	}
+
+	err = m.DB.InsertRoomCalendar(request.Context(), cal)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Calendar added, its bookings are imported at the next sync")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomCalendar stops importing a calendar, from /admin/rooms/{id}/calendars/{calendar}/delete,
+// and opens the room on the days it blocked
+func (m *Repository) AdminDeleteRoomCalendar(writer http.ResponseWriter, request *http.Request) {
+	id, calendarID, err := roomCalendarPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRoomCalendar(request.Context(), id, calendarID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Calendar removed")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminSyncRoomCalendar imports a calendar straight away, from /admin/rooms/{id}/calendars/{calendar}/sync
+func (m *Repository) AdminSyncRoomCalendar(writer http.ResponseWriter, request *http.Request) {
+	id, calendarID, err := roomCalendarPath(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	cal, err := m.DB.GetRoomCalendarByID(request.Context(), calendarID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && cal.RoomId != id) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	result, err := icalsync.New(m.App, m.DB).SyncCalendar(request.Context(), cal)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", "Couldn't sync the calendar: "+err.Error())
+	} else {
+		m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Calendar synced: %d added, %d updated, %d removed",
+			result.Added, result.Updated, result.Removed))
+	}
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// roomCalendarPath returns the room and calendar ids of urls like /admin/rooms/{id}/calendars/{calendar}/sync
+func roomCalendarPath(request *http.Request) (int, int, error) {
+	exploded := strings.Split(request.URL.Path, "/")
+	if len(exploded) < 6 {
+		return 0, 0, errors.New("missing url parameter")
+	}
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		return 0, 0, err
+	}
+	calendarID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, calendarID, nil
+}
+
+// calendarURL checks the link of a calendar feed, turning the webcal:// links some channels hand
+// out into the https:// address they stand for
+func calendarURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(u.Scheme, "webcal") {
+		u.Scheme = "https"
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("calendar url must be an http or https address")
+	}
+	return u.String(), nil
+}
+
+// renderAdminRoom renders the room form, with the seasonal rates and imported calendars of a saved room
+func (m *Repository) renderAdminRoom(writer http.ResponseWriter, request *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	if room.ID > 0 {
+		rates, err := m.DB.GetRoomRates(request.Context(), room.ID)
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		data["rates"] = rates
+		data["feed_url"] = m.roomFeedURL(request, room)
+
+		calendars, err := m.DB.GetRoomCalendars(request.Context(), room.ID)
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		data["calendars"] = calendars
+	}
+
+	render.Template(writer, request, "admin-room.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPromoCodes lists the promo codes
+func (m *Repository) AdminPromoCodes(writer http.ResponseWriter, request *http.Request) {
+	codes, err := m.DB.AllPromoCodes(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+
+	render.Template(writer, request, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowPromoCode shows the form for the promo code at /admin/promo-codes/{id}, or an empty one for /admin/promo-codes/new
+func (m *Repository) AdminShowPromoCode(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	code := models.PromoCode{DiscountType: models.DiscountPercent, MinNights: 1, Active: true}
+	if id > 0 {
+		code, err = m.DB.GetPromoCodeByID(request.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(writer, request, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+	}
+
+	m.renderAdminPromoCode(writer, request, code, forms.New(nil))
+}
+
+// AdminPostPromoCode saves a promo code, adding it when it is new
+func (m *Repository) AdminPostPromoCode(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	id, err := adminPathID(request)
+	if err != nil {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("code", "discount_type", "amount", "start_date", "end_date")
+
+	code := models.PromoCode{
+		ID:           id,
+		Code:         strings.ToUpper(strings.TrimSpace(form.Get("code"))),
+		Description:  form.Get("description"),
+		DiscountType: form.Get("discount_type"),
+		Active:       form.Has("active"),
+	}
+
+	if code.Code != "" && !promoCodePattern.MatchString(code.Code) {
+		form.Errors.Add("code", "Use 3 to 20 letters, digits and dashes")
+	}
+
+	switch code.DiscountType {
+	case models.DiscountPercent:
+		code.Amount, err = strconv.Atoi(form.Get("amount"))
+		if err != nil || code.Amount < 1 || code.Amount > 100 {
+			form.Errors.Add("amount", "Enter a percentage from 1 to 100")
+		}
+	case models.DiscountFixed:
+		code.Amount, err = parseMoney(form.Get("amount"))
+		if err != nil || code.Amount <= 0 {
+			form.Errors.Add("amount", "Enter the amount off, like 25.00")
+		}
+	default:
+		form.Errors.Add("discount_type", "Choose a discount type")
+	}
+
+	layout := "2006-01-02"
+	if form.IsDate("start_date") {
+		code.StartDate, _ = time.Parse(layout, form.Get("start_date"))
+	}
+	if form.IsDate("end_date") {
+		code.EndDate, _ = time.Parse(layout, form.Get("end_date"))
+		if code.EndDate.Before(code.StartDate) {
+			form.Errors.Add("end_date", "The last arrival date can't be before the first")
+		}
+	}
+
+	code.MinNights, err = strconv.Atoi(form.Get("min_nights"))
+	if err != nil || code.MinNights < 1 {
+		form.Errors.Add("min_nights", "Enter a number of nights")
+	}
+
+	if form.Has("max_redemptions") {
+		code.MaxRedemptions, err = strconv.Atoi(form.Get("max_redemptions"))
+		if err != nil || code.MaxRedemptions < 0 {
+			form.Errors.Add("max_redemptions", "Enter a number, or leave it blank for no limit")
+		}
+	}
+
+	for _, roomID := range request.PostForm["room_ids"] {
+		roomID, err := strconv.Atoi(roomID)
+		if err != nil {
+			helpers.ClientError(writer, request, http.StatusBadRequest)
+			return
+		}
+		code.RoomIds = append(code.RoomIds, roomID)
+	}
+
+	if form.Valid() {
+		other, err := m.DB.GetPromoCodeByCode(request.Context(), code.Code)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(writer, request, err)
+			return
+		}
+		if err == nil && other.ID != code.ID {
+			form.Errors.Add("code", "Another promo code already uses this code")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminPromoCode(writer, request, code, form)
+		return
+	}
+
+	if id == 0 {
+		_, err = m.DB.InsertPromoCode(request.Context(), code)
+	} else {
+		err = m.DB.UpdatePromoCode(request.Context(), code)
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Promo code saved")
+	http.Redirect(writer, request, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode deletes a promo code from /admin/promo-codes/{id}/delete, unless a booking has used it
+func (m *Repository) AdminDeletePromoCode(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeletePromoCode(request.Context(), id)
+	if errors.Is(err, repository.ErrPromoCodeRedeemed) {
+		m.App.Session.Put(request.Context(), "error", "Guests have booked with this code. Deactivate it instead.")
+		http.Redirect(writer, request, fmt.Sprintf("/admin/promo-codes/%d", id), http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Promo code deleted")
+	http.Redirect(writer, request, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderAdminPromoCode renders the promo code form
+func (m *Repository) renderAdminPromoCode(writer http.ResponseWriter, request *http.Request, code models.PromoCode, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	eligible := make(map[int]bool)
+	for _, id := range code.RoomIds {
+		eligible[id] = true
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = code
+	data["rooms"] = rooms
+	data["eligible"] = eligible
+
+	render.Template(writer, request, "admin-promo-code.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// promoCodePattern matches the codes guests can type in, which are stored in upper case
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9-]{3,20}$`)
+
+// slugPattern matches the room addresses used in /rooms/{slug}
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// slugify turns a room name like "Major's Suite" into majors-suite
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// lines splits a textarea into its non-blank lines
+func lines(s string) []string {
+	var items []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// AdminMail lists the latest emails in the outbox, narrowed down to one status with ?status=
+func (m *Repository) AdminMail(writer http.ResponseWriter, request *http.Request) {
+	status := request.URL.Query().Get("status")
+	known := status == ""
+	for _, s := range models.MailStatuses {
+		if s == status {
+			known = true
+		}
+	}
+	if !known {
+		helpers.ClientError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := m.DB.ListMail(request.Context(), status, 100)
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	counts, err := m.DB.CountMailByStatus(request.Context())
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["counts"] = counts
+	data["statuses"] = models.MailStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	render.Template(writer, request, "admin-mail.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminShowMail shows an email in the outbox at /admin/mail/{id}, with the log of every attempt at sending it
+func (m *Repository) AdminShowMail(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	msg, err := m.DB.GetMailByID(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+
+	render.Template(writer, request, "admin-mail-show.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendMail puts a sent or failed email back in the outbox, from /admin/mail/{id}/resend
+func (m *Repository) AdminResendMail(writer http.ResponseWriter, request *http.Request) {
+	id, err := adminPathID(request)
+	if err != nil || id == 0 {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/mail/%d", id)
+
+	err = m.DB.ResendMail(request.Context(), id)
+	if errors.Is(err, repository.ErrMailQueued) {
+		m.App.Session.Put(request.Context(), "error", "This email is already waiting to be sent")
+		http.Redirect(writer, request, back, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, request, err)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Email queued to be sent again")
+	http.Redirect(writer, request, back, http.StatusSeeOther)
+}
+
+// parseMoney reads an amount in dollars, like $1,234.50, as cents. A blank amount is zero
+func parseMoney(s string) (int, error) {
+	s = strings.NewReplacer("$", "", ",", "").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	dollars, cents, found := strings.Cut(s, ".")
+	if !found {
+		cents = "00"
+	}
+	if len(cents) == 1 {
+		cents += "0"
+	}
+	if dollars == "" {
+		dollars = "0"
+	}
+
+	if len(cents) != 2 || strings.Trim(dollars+cents, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	d, err := strconv.Atoi(dollars)
+	if err != nil {
+		return 0, err
+	}
+	c, _ := strconv.Atoi(cents)
+
+	return d*100 + c, nil
+}
@@ -5,13 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Seician/bookings/internal/driver"
+	"github.com/Seician/bookings/internal/ical"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/payments"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type postData struct {
@@ -62,7 +66,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomId: 1,
+		RoomId:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -83,6 +89,10 @@ func TestRepository_Reservation(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+	// a Saturday at the weekend rate and a Sunday at the nightly rate
+	if !strings.Contains(rr.Body.String(), "$250.00") {
+		t.Error("Reservation handler did not show the price of the stay")
+	}
 
 	// test case where reeservation is not in session (reset everything)
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
@@ -108,6 +118,20 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
+	// test when the stay can't be priced
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	reservation.RoomId = 1
+	reservation.StartDate = time.Date(2039, 1, 1, 0, 0, 0, 0, time.UTC)
+	session.Put(ctx, "reservation", reservation)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
 }
 
 func TestRepository_PostReservation(t *testing.T) {
@@ -134,6 +158,10 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	// one Saturday night at the weekend rate
+	if res, _ := session.Get(ctx, "reservation").(models.Reservation); res.TotalPrice != 15000 {
+		t.Errorf("PostReservation handler stored a total price of %d, wanted 15000", res.TotalPrice)
+	}
 
 	// test for missing post body
 	req, _ = http.NewRequest("POST", "/make-reservation", nil)
@@ -238,8 +266,8 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
 	// test for failure to insert reservation into database
@@ -287,6 +315,392 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// test for room booked by someone else in the meantime
+	reqBody = "start_date=2050-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=3")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler for unavailable room: got %d to %q, wanted %d to /search-availability", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+	if session.GetString(ctx, "error") == "" {
+		t.Error("PostReservation handler did not explain that the room is unavailable")
+	}
+
+	// test for a room that has been deactivated
+	reqBody = strings.Replace(reqBody, "room_id=3", "room_id=97", 1)
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler for inactive room: got %d to %q, wanted %d to /search-availability", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+	reqBody = strings.Replace(reqBody, "room_id=97", "room_id=3", 1)
+
+	// test for a stay that can't be priced
+	reqBody = strings.Replace(reqBody, "start_date=2050-01-01", "start_date=2039-12-31", 1)
+	reqBody = strings.Replace(reqBody, "room_id=3", "room_id=1", 1)
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostReservation handler for a stay that can't be priced: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestRepository_PostReservationPromoCode(t *testing.T) {
+	// one Saturday night in room 1, at the weekend rate of $150.00
+	body := "start_date=2050-01-01&end_date=2050-01-02&first_name=John&last_name=Smith&email=john@smith.com&room_id=1"
+
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+		expectedInBody     string
+		expectedTotal      int
+	}{
+		{"valid", "summer10", http.StatusSeeOther, "", 13500},
+		{"unknown", "NOPE", http.StatusOK, "we don&#39;t recognise this code", 0},
+		{"expired", "EXPIRED", http.StatusOK, "not valid for stays arriving", 0},
+		{"used up meanwhile", "LASTONE", http.StatusOK, "just been used up", 0},
+		{"database error", "error", http.StatusInternalServerError, "", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body+"&promo_code="+e.code))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+		if e.expectedTotal > 0 {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.TotalPrice != e.expectedTotal || res.Discount != 1500 || res.PromoCode != "SUMMER10" {
+				t.Errorf("%s: got total %d with %d off for %q, wanted %d with 1500 off for SUMMER10",
+					e.name, res.TotalPrice, res.Discount, res.PromoCode, e.expectedTotal)
+			}
+		}
+	}
+}
+
+func TestRepository_PostReservationDeposit(t *testing.T) {
+	app.Payments.DepositPercent = 20
+	defer func() { app.Payments.DepositPercent = 0 }()
+
+	// one Saturday night at $150.00, so a deposit of $30.00
+	body := "start_date=2050-01-01&end_date=2050-01-02&first_name=John&last_name=Smith&email=john@smith.com"
+
+	var tests = []struct {
+		name               string
+		roomID             string
+		method             string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedInBody     string
+	}{
+		{"paid", "1", payments.MethodSuccess, http.StatusSeeOther, "/reservation-summary", ""},
+		{"no payment method", "1", "", http.StatusOK, "", "This field cannot be blank"},
+		{"declined", "1", payments.MethodDeclined, http.StatusOK, "", "your card was declined"},
+		{"3-D Secure", "1", payments.MethodThreeDS, http.StatusSeeOther, payments.FakePath + "/3ds/", ""},
+		{"booking fails", "2", payments.MethodSuccess, http.StatusTemporaryRedirect, "/", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body+"&room_id="+e.roomID+"&payment_method="+e.method))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && !strings.HasPrefix(rr.Header().Get("Location"), e.expectedLocation) {
+			t.Errorf("%s: redirected to %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+		if e.name == "paid" {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.Deposit != 3000 || res.PaymentStatus != payments.StatusCaptured {
+				t.Errorf("%s: got a %q deposit of %d, wanted a captured deposit of 3000", e.name, res.PaymentStatus, res.Deposit)
+			}
+		}
+	}
+}
+
+func TestRepository_ReservationPayment(t *testing.T) {
+	fake := app.PaymentProvider.(*payments.Fake)
+
+	// room 2 fails to be inserted, so the checks that fail must not get as far as booking it
+	var tests = []struct {
+		name               string
+		result             string
+		paymentID          string
+		roomID             int
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"approved", "approve", "", 1, http.StatusSeeOther, "/reservation-summary"},
+		{"failed", "fail", "", 2, http.StatusSeeOther, "/make-reservation"},
+		{"check not finished", "", "", 2, http.StatusSeeOther, "/make-reservation"},
+		{"other payment", "approve", "fake_0", 1, http.StatusTemporaryRedirect, "/"},
+	}
+
+	for _, e := range tests {
+		payment, err := fake.Authorize(context.Background(), payments.AuthorizeRequest{
+			Amount:        3000,
+			PaymentMethod: payments.MethodThreeDS,
+			ReturnURL:     "/make-reservation/payment",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the guest passes or fails the check on the provider's page
+		if e.result != "" {
+			challenge := httptest.NewRequest("GET", strings.TrimPrefix(payment.RedirectURL, payments.FakePath)+"?result="+e.result, nil)
+			fake.ServeHTTP(httptest.NewRecorder(), challenge)
+		}
+
+		if e.paymentID == "" {
+			e.paymentID = payment.ID
+		}
+
+		req, _ := http.NewRequest("GET", "/make-reservation/payment?payment_id="+e.paymentID, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "pending_reservation", models.Reservation{
+			RoomId:    e.roomID,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+			Deposit:   3000,
+			PaymentId: payment.ID,
+		})
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ReservationPayment)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: redirected to %q, wanted %q", e.name, loc, e.expectedLocation)
+		}
+		// a check that didn't pass leaves nothing held
+		if p, _ := fake.Get(context.Background(), payment.ID); e.roomID == 2 && p.Status == payments.StatusRequiresAction {
+			t.Errorf("%s: the payment is still waiting for its check", e.name)
+		}
+	}
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	fake := app.PaymentProvider.(*payments.Fake)
+
+	var tests = []struct {
+		name               string
+		payload            string
+		signature          string
+		expectedStatusCode int
+	}{
+		{"captured", `{"type":"payment.captured","payment_id":"fake_1"}`, "", http.StatusOK},
+		{"unknown event", `{"type":"payment.disputed","payment_id":"fake_1"}`, "", http.StatusOK},
+		{"unknown payment", `{"type":"payment.refunded","payment_id":"missing"}`, "", http.StatusOK},
+		{"database error", `{"type":"payment.declined","payment_id":"fail"}`, "", http.StatusInternalServerError},
+		{"bad signature", `{"type":"payment.captured","payment_id":"fake_1"}`, "forged", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		if e.signature == "" {
+			e.signature = fake.Sign([]byte(e.payload))
+		}
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(e.payload))
+		req.Header.Set(payments.SignatureHeader, e.signature)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_ReservationMails(t *testing.T) {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := Repo.reservationMailData(models.Reservation{
+		ID:         7,
+		FirstName:  "John",
+		LastName:   "O'Brien",
+		Email:      "john@smith.com",
+		StartDate:  start,
+		EndDate:    start.AddDate(0, 0, 3),
+		Room:       models.Room{RoomName: "Major's Suite"},
+		TotalPrice: 45000,
+	})
+	data.ManageURL = "http://localhost/cancel-reservation/abc"
+	data.AdminURL = "http://localhost/admin/reservations/new/7"
+
+	var tests = []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"confirmation", "confirmation", []string{"Dear John", "Major", "Saturday, January 1, 2050", "Tuesday, January 4, 2050", "$450.00", "http://localhost/cancel-reservation/abc"}},
+		{"owner", "owner-notification", []string{"John O", "john@smith.com", "$450.00", "http://localhost/admin/reservations/new/7"}},
+		{"cancellation", "cancellation", []string{"Dear John", "has been cancelled"}},
+	}
+
+	for _, e := range tests {
+		msg, err := Repo.mailFromTemplate("john@smith.com", "Subject", e.template, data)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if msg.From != "bookings@example.com" {
+			t.Errorf("%s: sent from %q, wanted the configured sender", e.name, msg.From)
+		}
+		for _, want := range e.expected {
+			if !strings.Contains(msg.Content, want) {
+				t.Errorf("%s: did not find %q in the HTML body", e.name, want)
+			}
+			if !strings.Contains(msg.PlainContent, want) {
+				t.Errorf("%s: did not find %q in the text body", e.name, want)
+			}
+		}
+		if !strings.HasPrefix(strings.TrimSpace(msg.Content), "<!doctype html>") {
+			t.Errorf("%s: HTML body is not laid out as a document", e.name)
+		}
+	}
+
+	// the text version isn't escaped like the HTML one
+	msg, _ := Repo.mailFromTemplate("owner@example.com", "Subject", "owner-notification", data)
+	if !strings.Contains(msg.PlainContent, "O'Brien") || !strings.Contains(msg.Content, "O&#39;Brien") {
+		t.Error("guest name is not escaped for HTML only")
+	}
+
+	// without an invoice the guest is told it will follow and the owner to send it
+	data.InvoiceMissing = true
+	msg, _ = Repo.mailFromTemplate("john@smith.com", "Subject", "confirmation", data)
+	if !strings.Contains(msg.PlainContent, "send you your invoice separately") || strings.Contains(msg.PlainContent, "attached") {
+		t.Error("confirmation without an invoice says it's attached")
+	}
+	msg, _ = Repo.mailFromTemplate("owner@example.com", "Subject", "owner-notification", data)
+	if !strings.Contains(msg.PlainContent, "invoice couldn't be issued") || !strings.Contains(msg.Content, "invoice couldn't be issued") {
+		t.Error("owner isn't told the invoice couldn't be issued")
+	}
+
+	if _, err := Repo.mailFromTemplate("john@smith.com", "Subject", "missing", data); err == nil {
+		t.Error("rendered an email template that does not exist")
+	}
+}
+
+func TestRepository_RoomCalendarFeed(t *testing.T) {
+	app.ICal.FeedSecret = "feed-secret"
+	defer func() { app.ICal.FeedSecret = "" }()
+
+	token := ical.FeedToken("feed-secret", 1)
+
+	var tests = []struct {
+		name               string
+		url                string
+		secret             string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"reservation", "/ical/generals-quarters.ics?token=" + token, "feed-secret", http.StatusOK, "UID:reservation-1@fortsmythe.example"},
+		{"block", "/ical/majors-suite.ics?token=" + ical.FeedToken("feed-secret", 2), "feed-secret", http.StatusOK, "SUMMARY:Owner Block"},
+		{"wrong token", "/ical/majors-suite.ics?token=" + token, "feed-secret", http.StatusForbidden, ""},
+		{"no token", "/ical/generals-quarters.ics", "feed-secret", http.StatusForbidden, ""},
+		{"unknown room", "/ical/attic.ics?token=" + token, "feed-secret", http.StatusNotFound, ""},
+		{"not ics", "/ical/generals-quarters?token=" + token, "feed-secret", http.StatusNotFound, ""},
+		{"database error", "/ical/error.ics?token=" + token, "feed-secret", http.StatusInternalServerError, ""},
+		{"feeds off", "/ical/generals-quarters.ics?token=" + ical.FeedToken("", 1), "", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		app.ICal.FeedSecret = e.secret
+
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomCalendarFeed)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+		if rr.Code == http.StatusOK && strings.Contains(rr.Body.String(), "John") {
+			t.Errorf("%s: feed shows who booked the room", e.name)
+		}
+		if strings.Contains(rr.Body.String(), "External Booking") {
+			t.Errorf("%s: feed sends back a booking imported from another channel", e.name)
+		}
+	}
+}
+
+func TestRepository_StayCalendar(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	req.Host = "localhost:8080"
+
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	ics, err := Repo.stayCalendar(req, models.Reservation{ID: 7, StartDate: start, EndDate: start.AddDate(0, 0, 3)},
+		"http://bookings.example.com/cancel-reservation/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"METHOD:PUBLISH", "UID:reservation-7@fortsmythe.example", "DTSTART;VALUE=DATE:20500101", "DTEND;VALUE=DATE:20500104"} {
+		if !strings.Contains(string(ics), want) {
+			t.Errorf("did not find %q in the calendar", want)
+		}
+	}
 }
 
 func TestNewRepo(t *testing.T) {
@@ -363,6 +777,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Post availability when rooms are available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+	if !strings.Contains(rr.Body.String(), "$100.00 for 1 night") {
+		t.Error("Post availability did not show the price of the available room")
+	}
 
 	/*****************************************
 	// third case -- empty post body
@@ -484,6 +901,35 @@ func TestRepository_PostAvailability(t *testing.T) {
 	}
 }
 
+func TestRepository_PostAvailability_Metrics(t *testing.T) {
+	var tests = []struct {
+		name          string
+		start         string
+		expectedEmpty float64
+	}{
+		{"rooms available", "2040-01-01", 0},
+		{"no availability", "2050-01-01", 1},
+	}
+
+	for _, e := range tests {
+		searches := testutil.ToFloat64(app.Metrics.AvailabilitySearches)
+		empty := testutil.ToFloat64(app.Metrics.NoAvailability)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader("start="+e.start+"&end=2060-01-02"))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := testutil.ToFloat64(app.Metrics.AvailabilitySearches) - searches; got != 1 {
+			t.Errorf("%s: counted %g searches, wanted 1", e.name, got)
+		}
+		if got := testutil.ToFloat64(app.Metrics.NoAvailability) - empty; got != e.expectedEmpty {
+			t.Errorf("%s: counted %g searches without availability, wanted %g", e.name, got, e.expectedEmpty)
+		}
+	}
+}
+
 func TestRepository_AvailabilityJSON(t *testing.T) {
 	/*****************************************
 	// first case -- rooms are not available
@@ -748,6 +1194,23 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	///*****************************************
+	//// fourth case -- room has been deactivated
+	//*****************************************/
+	req, _ = http.NewRequest("GET", "/choose-room/97", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/choose-room/97"
+
+	rr = httptest.NewRecorder()
+	session.Put(ctx, "reservation", reservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("ChooseRoom handler for inactive room: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
 }
 
 func TestRepository_BookRoom(t *testing.T) {
@@ -793,6 +1256,1010 @@ func TestRepository_BookRoom(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	/*****************************************
+	// third case -- room has been deactivated
+	*****************************************/
+	req, _ = http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=97", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("BookRoom handler for inactive room: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+	if session.GetString(ctx, "error") != "Sorry, this room isn't taking bookings" {
+		t.Errorf("BookRoom handler for inactive room put %q in the session", session.GetString(ctx, "error"))
+	}
+}
+
+func TestRepository_AdminReservations(t *testing.T) {
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		url                string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"new", Repo.AdminNewReservations, "/admin/reservations-new", http.StatusOK, "New Reservations"},
+		{"all", Repo.AdminAllReservations, "/admin/reservations-all", http.StatusOK, "Smith, John"},
+		{"filtered", Repo.AdminAllReservations, "/admin/reservations-all?from=2050-01-01&to=2050-02-01&room=1&status=new&q=smith&sort=name&dir=desc&page=2", http.StatusOK, "Smith, John"},
+		{"bad filter values", Repo.AdminAllReservations, "/admin/reservations-all?from=x&room=y&page=z", http.StatusOK, "Smith, John"},
+		{"database error", Repo.AdminAllReservations, "/admin/reservations-all?q=error", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminShowReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"found", "/admin/reservations/new/1", http.StatusOK},
+		{"bad id", "/admin/reservations/new/x", http.StatusNotFound},
+		{"missing id", "/admin/reservations/new", http.StatusNotFound},
+		{"not found", "/admin/reservations/all/99", http.StatusNotFound},
+		{"database error", "/admin/reservations/all/100", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminReservationInvoice(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"found", "/admin/reservations/all/1/invoice", http.StatusOK},
+		{"bad id", "/admin/reservations/all/x/invoice", http.StatusNotFound},
+		{"not found", "/admin/reservations/all/99/invoice", http.StatusNotFound},
+		{"database error", "/admin/reservations/all/100/invoice", http.StatusInternalServerError},
+		{"invoice error", "/admin/reservations/all/98/invoice", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode != http.StatusOK {
+			continue
+		}
+		if rr.Header().Get("Content-Type") != "application/pdf" || !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+			t.Errorf("%s: did not get a PDF", e.name)
+		}
+		if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="INV-000042.pdf"` {
+			t.Errorf("%s: got Content-Disposition %q", e.name, cd)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	valid := "first_name=John&last_name=Smith&email=john@smith.com&phone=555&start_date=2050-01-01&end_date=2050-01-03"
+
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedInBody     string
+	}{
+		{"saved", "/admin/reservations/new/1", valid + "&room_id=1", http.StatusSeeOther, "/admin/reservations-new", ""},
+		{"saved from all", "/admin/reservations/all/1", valid + "&room_id=2", http.StatusSeeOther, "/admin/reservations-all", ""},
+		{"invalid form", "/admin/reservations/all/1", "first_name=J&email=x&start_date=2050-01-05&end_date=2050-01-01&room_id=1", http.StatusOK, "", "Departure must be after arrival"},
+		{"bad dates", "/admin/reservations/all/1", "first_name=John&last_name=Smith&email=john@smith.com&start_date=x&end_date=y&room_id=1", http.StatusOK, "", "Invalid date"},
+		{"room unavailable", "/admin/reservations/all/1", valid + "&room_id=3", http.StatusOK, "", "not available"},
+		{"database error", "/admin/reservations/all/1", valid + "&room_id=1000", http.StatusInternalServerError, "", ""},
+		{"not found", "/admin/reservations/all/99", valid + "&room_id=1", http.StatusNotFound, "", ""},
+		{"bad id", "/admin/reservations/all/x", valid + "&room_id=1", http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: got location %s, wanted %s", e.name, actualLoc.String(), e.expectedLocation)
+			}
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminProcessReservation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/process-reservation/new/1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminProcessReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("got status %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/admin/reservations-new" {
+		t.Errorf("got location %s, wanted /admin/reservations-new", actualLoc.String())
+	}
+}
+
+func TestRepository_CancelReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedInBody     string
+	}{
+		{"show", "GET", "/cancel-reservation/abc", http.StatusOK, "", "Cancel my reservation"},
+		{"show checked in", "GET", "/cancel-reservation/arrived", http.StatusOK, "", "can no longer be cancelled"},
+		{"show missing", "GET", "/cancel-reservation/missing", http.StatusNotFound, "", ""},
+		{"show no token", "GET", "/cancel-reservation/", http.StatusNotFound, "", ""},
+		{"show database error", "GET", "/cancel-reservation/error", http.StatusInternalServerError, "", ""},
+		{"cancel", "POST", "/cancel-reservation/abc", http.StatusSeeOther, "/", ""},
+		{"cancel checked in", "POST", "/cancel-reservation/arrived", http.StatusSeeOther, "/", ""},
+		{"cancel missing", "POST", "/cancel-reservation/missing", http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ShowCancelReservation)
+		if e.method == "POST" {
+			handler = Repo.PostCancelReservation
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: got location %s, wanted %s", e.name, actualLoc.String(), e.expectedLocation)
+			}
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationStatus(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		status             string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"check in", "/admin/reservation-status/all/1", "checked-in", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"cancel", "/admin/reservation-status/new/1", "cancelled", http.StatusSeeOther, "/admin/reservations/new/1"},
+		{"not cancellable", "/admin/reservation-status/all/2", "cancelled", http.StatusSeeOther, "/admin/reservations/all/2"},
+		{"back to pending", "/admin/reservation-status/all/2", "pending", http.StatusSeeOther, "/admin/reservations/all/2"},
+		{"unknown status", "/admin/reservation-status/all/1", "gone", http.StatusBadRequest, ""},
+		{"not found", "/admin/reservation-status/all/99", "confirmed", http.StatusNotFound, ""},
+		{"bad id", "/admin/reservation-status/all/x", "confirmed", http.StatusNotFound, ""},
+		{"database error", "/admin/reservation-status/all/100", "confirmed", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader("status="+e.status))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: got location %s, wanted %s", e.name, actualLoc.String(), e.expectedLocation)
+			}
+		}
+	}
+}
+
+func TestRepository_releaseDeposit(t *testing.T) {
+	fake := Repo.App.PaymentProvider
+	ctx := context.Background()
+
+	var tests = []struct {
+		name           string
+		capture        bool
+		expectedStatus string
+	}{
+		{"held", false, payments.StatusVoided},
+		{"taken", true, payments.StatusRefunded},
+	}
+
+	for _, e := range tests {
+		payment, err := fake.Authorize(ctx, payments.AuthorizeRequest{Amount: 3000, PaymentMethod: payments.MethodSuccess})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.capture {
+			payment, err = fake.Capture(ctx, payment.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		Repo.releaseDeposit(ctx, models.Reservation{PaymentId: payment.ID, PaymentStatus: payment.Status})
+
+		payment, err = fake.Get(ctx, payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.Status != e.expectedStatus {
+			t.Errorf("%s: deposit is %s, wanted %s", e.name, payment.Status, e.expectedStatus)
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	var tests = []struct {
+		name           string
+		url            string
+		expectedInBody []string
+	}{
+		{"month", "/admin/reservations-calendar?y=2050&m=1", []string{"January 2050", "y=2049&m=12", "y=2050&m=02", "/admin/reservations/all/1", "Owner Block"}},
+		{"bad month", "/admin/reservations-calendar?y=2050&m=13", []string{"2050"}},
+		{"current month", "/admin/reservations-calendar", []string{time.Now().Format("January 2006")}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		for _, expected := range e.expectedInBody {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("%s: did not find %q in response", e.name, expected)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminBlocks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/blocks", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminBlocks)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Maintenance") || !strings.Contains(body, "/admin/blocks/7/delete") {
+		t.Error("upcoming block is not listed")
+	}
+	if strings.Contains(body, ">Reservation</option>") {
+		t.Error("reservations should not be offered as a kind of block")
+	}
+}
+
+func TestRepository_AdminPostBlock(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"valid", "room_id=1&restriction_id=3&start_date=2040-01-01&end_date=2040-01-03", http.StatusSeeOther, ""},
+		{"single day", "room_id=1&restriction_id=2&start_date=2040-01-01&end_date=2040-01-01", http.StatusSeeOther, ""},
+		{"missing fields", "", http.StatusOK, "This field cannot be blank"},
+		{"last day first", "room_id=1&restriction_id=3&start_date=2040-01-03&end_date=2040-01-01", http.StatusOK, "Last day can&#39;t be before the first day"},
+		{"reservation kind", "room_id=1&restriction_id=1&start_date=2040-01-01&end_date=2040-01-03", http.StatusOK, "Choose the kind of block"},
+		{"external kind", "room_id=1&restriction_id=5&start_date=2040-01-01&end_date=2040-01-03", http.StatusOK, "Choose the kind of block"},
+		{"bad room", "room_id=x&restriction_id=3&start_date=2040-01-01&end_date=2040-01-03", http.StatusOK, "Choose a room"},
+		{"room taken", "room_id=1&restriction_id=3&start_date=2050-01-01&end_date=2050-01-03", http.StatusOK, "already reserved or blocked"},
+		{"availability error", "room_id=1&restriction_id=3&start_date=2060-01-01&end_date=2060-01-03", http.StatusInternalServerError, ""},
+		{"insert error", "room_id=1000&restriction_id=3&start_date=2040-01-01&end_date=2040-01-03", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteBlock(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"deleted", "/admin/blocks/7/delete", http.StatusSeeOther},
+		{"not a block", "/admin/blocks/99/delete", http.StatusNotFound},
+		{"bad id", "/admin/blocks/x/delete", http.StatusNotFound},
+		{"database error", "/admin/blocks/100/delete", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminRooms(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/rooms", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminRooms)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+		t.Error("rooms are not listed")
+	}
+}
+
+func TestRepository_AdminShowRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"existing", "/admin/rooms/1", http.StatusOK, "A room fit for a general"},
+		{"imported calendars", "/admin/rooms/1", http.StatusOK, "/admin/rooms/1/calendars/2/sync"},
+		{"new", "/admin/rooms/new", http.StatusOK, "New Room"},
+		{"not found", "/admin/rooms/99", http.StatusNotFound, ""},
+		{"bad id", "/admin/rooms/x", http.StatusNotFound, ""},
+		{"database error", "/admin/rooms/100", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"insert", "/admin/rooms/new", "room_name=Colonel's Cabin&description=Cosy&capacity=2&active=1&base_rate=99.00", http.StatusSeeOther, ""},
+		{"update", "/admin/rooms/1", "room_name=General's Quarters&capacity=3&base_rate=$1,099.50&weekend_rate=129", http.StatusSeeOther, ""},
+		{"invalid", "/admin/rooms/1", "room_name=G&capacity=none&base_rate=99", http.StatusOK, "Capacity must be a number of guests"},
+		{"missing rate", "/admin/rooms/1", "room_name=General's Quarters&capacity=3", http.StatusOK, "Enter the nightly rate"},
+		{"invalid weekend rate", "/admin/rooms/1", "room_name=General's Quarters&capacity=3&base_rate=99&weekend_rate=lots", http.StatusOK, "Enter the weekend rate"},
+		{"insert error", "/admin/rooms/new", "room_name=fail&capacity=2&base_rate=99", http.StatusInternalServerError, ""},
+		{"update error", "/admin/rooms/1", "room_name=fail&capacity=2&base_rate=99", http.StatusInternalServerError, ""},
+		{"bad id", "/admin/rooms/x", "room_name=General's Quarters&capacity=3", http.StatusNotFound, ""},
+		{"slug taken", "/admin/rooms/1", "room_name=General's Quarters&slug=majors-suite&capacity=3", http.StatusOK, "Another room already uses this address"},
+		{"bad slug", "/admin/rooms/1", "room_name=General's Quarters&slug=General's&capacity=3", http.StatusOK, "Use lower case letters"},
+		{"slug error", "/admin/rooms/1", "room_name=General's Quarters&slug=error&capacity=3", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomRate(t *testing.T) {
+	valid := "season_name=Summer&season_start=2050-07-01&season_end=2050-08-31&season_nightly_rate=200"
+
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"valid", "/admin/rooms/1/rates", valid, http.StatusSeeOther, ""},
+		{"with weekend rate", "/admin/rooms/1/rates", valid + "&season_weekend_rate=250.00", http.StatusSeeOther, ""},
+		{"missing fields", "/admin/rooms/1/rates", "season_name=Summer", http.StatusOK, "This field cannot be blank"},
+		{"ends before it starts", "/admin/rooms/1/rates", "season_name=Summer&season_start=2050-07-01&season_end=2050-06-30&season_nightly_rate=200", http.StatusOK, "The last night can&#39;t be before the first"},
+		{"invalid rate", "/admin/rooms/1/rates", "season_name=Summer&season_start=2050-07-01&season_end=2050-08-31&season_nightly_rate=lots", http.StatusOK, "Enter the nightly rate"},
+		{"insert error", "/admin/rooms/1/rates", "season_name=fail&season_start=2050-07-01&season_end=2050-08-31&season_nightly_rate=200", http.StatusInternalServerError, ""},
+		{"new room", "/admin/rooms/new/rates", valid, http.StatusNotFound, ""},
+		{"missing room", "/admin/rooms/99/rates", valid, http.StatusNotFound, ""},
+		{"database error", "/admin/rooms/100/rates", valid, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomRate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"delete", "/admin/rooms/1/rates/1/delete", http.StatusSeeOther},
+		{"missing rate", "/admin/rooms/1/rates/99/delete", http.StatusNotFound},
+		{"database error", "/admin/rooms/1/rates/100/delete", http.StatusInternalServerError},
+		{"bad rate id", "/admin/rooms/1/rates/x/delete", http.StatusNotFound},
+		{"bad room id", "/admin/rooms/x/rates/1/delete", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"valid", "/admin/rooms/1/calendars", "calendar_name=Airbnb&calendar_url=https://example.com/room.ics", http.StatusSeeOther, ""},
+		{"webcal link", "/admin/rooms/1/calendars", "calendar_name=Airbnb&calendar_url=webcal://example.com/room.ics", http.StatusSeeOther, ""},
+		{"missing fields", "/admin/rooms/1/calendars", "calendar_name=Airbnb", http.StatusOK, "This field cannot be blank"},
+		{"not a link", "/admin/rooms/1/calendars", "calendar_name=Airbnb&calendar_url=room.ics", http.StatusOK, "Enter the iCal link"},
+		{"insert error", "/admin/rooms/1/calendars", "calendar_name=fail&calendar_url=https://example.com/room.ics", http.StatusInternalServerError, ""},
+		{"new room", "/admin/rooms/new/calendars", "calendar_name=Airbnb&calendar_url=https://example.com/room.ics", http.StatusNotFound, ""},
+		{"missing room", "/admin/rooms/99/calendars", "calendar_name=Airbnb&calendar_url=https://example.com/room.ics", http.StatusNotFound, ""},
+		{"database error", "/admin/rooms/100/calendars", "calendar_name=Airbnb&calendar_url=https://example.com/room.ics", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"delete", "/admin/rooms/1/calendars/1/delete", http.StatusSeeOther},
+		{"missing calendar", "/admin/rooms/1/calendars/99/delete", http.StatusNotFound},
+		{"database error", "/admin/rooms/1/calendars/100/delete", http.StatusInternalServerError},
+		{"bad calendar id", "/admin/rooms/1/calendars/x/delete", http.StatusNotFound},
+		{"bad room id", "/admin/rooms/x/calendars/1/delete", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminSyncRoomCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"sync", "/admin/rooms/1/calendars/1/sync", http.StatusSeeOther},
+		{"calendar of another room", "/admin/rooms/2/calendars/1/sync", http.StatusNotFound},
+		{"missing calendar", "/admin/rooms/1/calendars/99/sync", http.StatusNotFound},
+		{"database error", "/admin/rooms/1/calendars/100/sync", http.StatusInternalServerError},
+		{"bad calendar id", "/admin/rooms/1/calendars/x/sync", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSyncRoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		// nothing serves the calendars of the test repository, so the sync reports why it failed
+		if rr.Code == http.StatusSeeOther && !strings.HasPrefix(session.GetString(ctx, "error"), "Couldn't sync the calendar") {
+			t.Errorf("%s: the failed sync was not reported", e.name)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected int
+		valid    bool
+	}{
+		{"", 0, true},
+		{"99", 9900, true},
+		{"99.5", 9950, true},
+		{"$1,234.56", 123456, true},
+		{".75", 75, true},
+		{"lots", 0, false},
+		{"-5", 0, false},
+		{"1.234", 0, false},
+	}
+
+	for _, e := range tests {
+		got, err := parseMoney(e.input)
+		if e.valid && (err != nil || got != e.expected) {
+			t.Errorf("parseMoney(%q) = %d, %v; wanted %d", e.input, got, err, e.expected)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("parseMoney(%q) should have failed", e.input)
+		}
+	}
+}
+
+func TestRepository_AdminPromoCodes(t *testing.T) {
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		url                string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"list", Repo.AdminPromoCodes, "/admin/promo-codes", http.StatusOK, "SUMMER10"},
+		{"show", Repo.AdminShowPromoCode, "/admin/promo-codes/2", http.StatusOK, "LASTONE"},
+		{"new", Repo.AdminShowPromoCode, "/admin/promo-codes/new", http.StatusOK, "New Promo Code"},
+		{"missing", Repo.AdminShowPromoCode, "/admin/promo-codes/99", http.StatusNotFound, ""},
+		{"database error", Repo.AdminShowPromoCode, "/admin/promo-codes/100", http.StatusInternalServerError, ""},
+		{"bad id", Repo.AdminShowPromoCode, "/admin/promo-codes/x", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminPostPromoCode(t *testing.T) {
+	valid := "discount_type=percent&amount=15&start_date=2050-06-01&end_date=2050-08-31&min_nights=2"
+
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"insert", "/admin/promo-codes/new", "code=autumn15&" + valid + "&room_ids=1&room_ids=2&active=1", http.StatusSeeOther, ""},
+		{"update", "/admin/promo-codes/1", "code=SUMMER10&" + valid + "&max_redemptions=100", http.StatusSeeOther, ""},
+		{"fixed amount", "/admin/promo-codes/new", "code=TAKE25&discount_type=fixed&amount=$25&start_date=2050-06-01&end_date=2050-08-31&min_nights=1", http.StatusSeeOther, ""},
+		{"missing fields", "/admin/promo-codes/new", "code=AUTUMN15", http.StatusOK, "This field cannot be blank"},
+		{"bad code", "/admin/promo-codes/new", "code=10+off&" + valid, http.StatusOK, "Use 3 to 20 letters"},
+		{"code taken", "/admin/promo-codes/new", "code=lastone&" + valid, http.StatusOK, "Another promo code already uses this code"},
+		{"bad percentage", "/admin/promo-codes/new", "code=AUTUMN15&discount_type=percent&amount=150&start_date=2050-06-01&end_date=2050-08-31&min_nights=1", http.StatusOK, "Enter a percentage from 1 to 100"},
+		{"bad type", "/admin/promo-codes/new", "code=AUTUMN15&discount_type=free&amount=15&start_date=2050-06-01&end_date=2050-08-31&min_nights=1", http.StatusOK, "Choose a discount type"},
+		{"ends before it starts", "/admin/promo-codes/new", "code=AUTUMN15&discount_type=percent&amount=15&start_date=2050-06-01&end_date=2050-05-01&min_nights=1", http.StatusOK, "The last arrival date can&#39;t be before the first"},
+		{"bad room", "/admin/promo-codes/new", "code=AUTUMN15&" + valid + "&room_ids=x", http.StatusBadRequest, ""},
+		{"insert error", "/admin/promo-codes/new", "code=fail&" + valid, http.StatusInternalServerError, ""},
+		{"lookup error", "/admin/promo-codes/new", "code=error&" + valid, http.StatusInternalServerError, ""},
+		{"bad id", "/admin/promo-codes/x", "code=AUTUMN15&" + valid, http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminDeletePromoCode(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"delete", "/admin/promo-codes/3/delete", http.StatusSeeOther, "/admin/promo-codes"},
+		{"redeemed", "/admin/promo-codes/1/delete", http.StatusSeeOther, "/admin/promo-codes/1"},
+		{"missing", "/admin/promo-codes/99/delete", http.StatusNotFound, ""},
+		{"database error", "/admin/promo-codes/100/delete", http.StatusInternalServerError, ""},
+		{"new", "/admin/promo-codes/new/delete", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeletePromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: redirected to %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_AdminMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"all", "/admin/mail", http.StatusOK, "Reservation confirmation"},
+		{"failed", "/admin/mail?status=failed", http.StatusOK, "connection refused"},
+		{"unknown status", "/admin/mail?status=lost", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: did not find %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminShowMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"sent", "/admin/mail/1", http.StatusOK, "Reservation confirmation"},
+		{"failed with deliveries", "/admin/mail/2", http.StatusOK, "Send Again"},
+		{"missing", "/admin/mail/99", http.StatusNotFound, ""},
+		{"database error", "/admin/mail/100", http.StatusInternalServerError, ""},
+		{"bad id", "/admin/mail/x", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: did not find %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminResendMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedError      string
+	}{
+		{"failed", "/admin/mail/2/resend", http.StatusSeeOther, "Email queued to be sent again", ""},
+		{"still queued", "/admin/mail/3/resend", http.StatusSeeOther, "", "This email is already waiting to be sent"},
+		{"missing", "/admin/mail/99/resend", http.StatusNotFound, "", ""},
+		{"database error", "/admin/mail/100/resend", http.StatusInternalServerError, "", ""},
+		{"bad id", "/admin/mail/x/resend", http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminResendMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: got flash %q, wanted %q", e.name, flash, e.expectedFlash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: got error %q, wanted %q", e.name, msg, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"deleted", "/admin/rooms/2/delete", http.StatusSeeOther, "/admin/rooms"},
+		{"has reservations", "/admin/rooms/1/delete", http.StatusSeeOther, "/admin/rooms/1"},
+		{"not found", "/admin/rooms/99/delete", http.StatusNotFound, ""},
+		{"new", "/admin/rooms/new/delete", http.StatusNotFound, ""},
+		{"database error", "/admin/rooms/100/delete", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: got location %s, wanted %s", e.name, actualLoc.String(), e.expectedLocation)
+			}
+		}
+	}
+}
+
+func TestRepository_Room(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"room", "/rooms/generals-quarters", http.StatusOK, "Breakfast included"},
+		{"inactive", "/rooms/closed", http.StatusNotFound, ""},
+		{"missing", "/rooms/colonels-cabin", http.StatusNotFound, ""},
+		{"no slug", "/rooms/", http.StatusNotFound, ""},
+		{"database error", "/rooms/error", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Room)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedInBody != "" && !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: did not find %q in response", e.name, e.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_RedirectToRoom(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/majors-suite", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.RedirectToRoom)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("got status %d, wanted %d", rr.Code, http.StatusMovedPermanently)
+	}
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/rooms/majors-suite" {
+		t.Errorf("got location %s, wanted /rooms/majors-suite", actualLoc.String())
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	var tests = map[string]string{
+		"Major's Suite":       "majors-suite",
+		"  The  Colonel - 2 ": "the-colonel-2",
+	}
+
+	for name, expected := range tests {
+		if got := slugify(name); got != expected {
+			t.Errorf("slugify(%q) = %q, wanted %q", name, got, expected)
+		}
+	}
 }
 
 func getCtx(req *http.Request) context.Context {
@@ -4,7 +4,13 @@ import (
 	"encoding/gob"
 	"fmt"
 	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/helpers"
+	"github.com/Seician/bookings/internal/logging"
+	"github.com/Seician/bookings/internal/mailer"
+	"github.com/Seician/bookings/internal/metrics"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/outbox"
+	"github.com/Seician/bookings/internal/payments"
 	"github.com/Seician/bookings/internal/render"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
@@ -12,17 +18,29 @@ import (
 	"github.com/justinas/nosurf"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	texttemplate "text/template"
 	"time"
 )
 
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":  render.HumanDate,
+	"formatDate": render.FormatDate,
+	"iterate":    render.Iterate,
+	"add":        render.Add,
+	"addDays":    render.AddDays,
+	"weekday":    render.Weekday,
+	"isWeekend":  render.IsWeekend,
+	"money":      render.Money,
+}
 
 func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
@@ -30,11 +48,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, app.InProduction, slog.LevelInfo)
 
 	// set up the session
 	session = scs.New()
@@ -44,11 +58,10 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
+	app.PaymentProvider = payments.NewFake("test-secret")
+	app.Metrics = metrics.New()
+	app.Property = config.PropertyConfig{Name: "Fort Smythe Bed and Breakfast", Email: "owner@example.com", Domain: "fortsmythe.example"}
+	app.SMTP.From = "bookings@example.com"
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -57,22 +70,24 @@ func TestMain(m *testing.M) {
 	}
 
 	app.TemplateCache = tc
+
+	mc, err := CreateTestMailTemplateCache()
+	if err != nil {
+		log.Fatal("cannot create mail template cache")
+	}
+	app.MailTemplates = mc
 	app.UseCache = true
 
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 
+	// mail is queued in the test repository, which never hands it out to be sent
+	app.MailQueue = outbox.New(&app, repo.DB, &mailer.Memory{})
+
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())
 }
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
@@ -83,8 +98,9 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/generals-quarters", Repo.RedirectToRoom)
+	mux.Get("/majors-suite", Repo.RedirectToRoom)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -154,3 +170,40 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	return myCache, nil
 }
+
+// CreateTestMailTemplateCache creates the email template cache
+func CreateTestMailTemplateCache() (config.MailTemplateCache, error) {
+	mc := config.MailTemplateCache{
+		HTML: map[string]*template.Template{},
+		Text: map[string]*texttemplate.Template{},
+	}
+
+	pages, err := filepath.Glob(fmt.Sprintf("%s/email/*.html.tmpl", pathToTemplates))
+	if err != nil {
+		return mc, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html.tmpl")
+
+		ht, err := template.New(filepath.Base(page)).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return mc, err
+		}
+		ht, err = ht.ParseGlob(fmt.Sprintf("%s/email/*.layout.tmpl", pathToTemplates))
+		if err != nil {
+			return mc, err
+		}
+
+		textPage := fmt.Sprintf("%s/email/%s.txt.tmpl", pathToTemplates, name)
+		tt, err := texttemplate.New(filepath.Base(textPage)).Funcs(texttemplate.FuncMap(functions)).ParseFiles(textPage)
+		if err != nil {
+			return mc, err
+		}
+
+		mc.HTML[name] = ht
+		mc.Text[name] = tt
+	}
+
+	return mc, nil
+}
@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/driver"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Commit and BuildTime describe the build, and are set when building with
+//
+//	go build -ldflags "-X github.com/Seician/bookings/internal/health.Commit=$(git rev-parse HEAD) -X github.com/Seician/bookings/internal/health.BuildTime=$(date -u +%FT%TZ)"
+//
+// Otherwise they come from the version control details Go records, when there are any
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Worker is background work readiness depends on
+type Worker interface {
+	Running() bool
+}
+
+// Checker answers the probes of the orchestrator running the application
+type Checker struct {
+	App  *config.AppConfig
+	DB   *driver.DB
+	Mail Worker
+}
+
+// New creates a checker for the application, its database pool and its mail worker
+func New(a *config.AppConfig, db *driver.DB, mail Worker) *Checker {
+	return &Checker{
+		App:  a,
+		DB:   db,
+		Mail: mail,
+	}
+}
+
+// BuildInfo describes the build that is running
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Info returns the build that is running
+func Info() BuildInfo {
+	info := BuildInfo{
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz answers as long as the process is up
+func (c *Checker) Healthz(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, status{Status: "ok"})
+}
+
+// Readyz answers 200 when the application can serve requests: the database answers a ping, the
+// templates are loaded and the mail worker is running. Otherwise it answers 503 with what failed.
+// Database errors can name hosts and users, so they are only logged
+func (c *Checker) Readyz(writer http.ResponseWriter, request *http.Request) {
+	checks := map[string]error{
+		"database":  c.pingDB(request.Context()),
+		"templates": c.templates(),
+		"mail":      c.mail(),
+	}
+	if err := checks["database"]; err != nil {
+		c.App.Logger.ErrorContext(request.Context(), "database is not ready", "error", err)
+		checks["database"] = errDatabaseUnavailable
+	}
+
+	s := status{Status: "ok", Checks: make(map[string]string)}
+	code := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			s.Checks[name] = err.Error()
+			s.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		s.Checks[name] = "ok"
+	}
+
+	writeJSON(writer, code, s)
+}
+
+// Version answers with the build that is running
+func (c *Checker) Version(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, Info())
+}
+
+// errDatabaseUnavailable is what Readyz reports when the database can't be reached
+var errDatabaseUnavailable = errors.New("database unavailable")
+
+// pingDB checks that the database answers within the query timeout
+func (c *Checker) pingDB(ctx context.Context) error {
+	if c.DB == nil || c.DB.SQL == nil {
+		return errors.New("not connected")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.App.DB.QueryTimeout)
+	defer cancel()
+	return c.DB.SQL.PingContext(ctx)
+}
+
+// templates checks that the page templates are loaded
+func (c *Checker) templates() error {
+	if len(c.App.TemplateCache) == 0 {
+		return errors.New("not loaded")
+	}
+	return nil
+}
+
+// mail checks that the mail worker is sending
+func (c *Checker) mail() error {
+	if c.Mail == nil || !c.Mail.Running() {
+		return errors.New("not running")
+	}
+	return nil
+}
+
+func writeJSON(writer http.ResponseWriter, code int, v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "      ")
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(code)
+	_, _ = writer.Write(out)
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/driver"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type worker bool
+
+func (w worker) Running() bool {
+	return bool(w)
+}
+
+func newTestChecker(t *testing.T, running bool) *Checker {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.SQL.Close() })
+
+	app := &config.AppConfig{
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		DB:            config.DBConfig{QueryTimeout: time.Second},
+		TemplateCache: map[string]*template.Template{"home.page.tmpl": template.New("home")},
+	}
+	return New(app, db, worker(running))
+}
+
+func get(handler http.HandlerFunc, v interface{}) int {
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/", nil))
+	_ = json.Unmarshal(rr.Body.Bytes(), v)
+	return rr.Code
+}
+
+func TestChecker_Healthz(t *testing.T) {
+	c := newTestChecker(t, false)
+
+	var s status
+	if code := get(c.Healthz, &s); code != http.StatusOK || s.Status != "ok" {
+		t.Errorf("got %d %+v, wanted 200 ok", code, s)
+	}
+}
+
+func TestChecker_Readyz(t *testing.T) {
+	c := newTestChecker(t, true)
+
+	var s status
+	if code := get(c.Readyz, &s); code != http.StatusOK || s.Status != "ok" {
+		t.Errorf("got %d %+v, wanted 200 ok", code, s)
+	}
+
+	// every check that fails is reported
+	c.Mail = worker(false)
+	c.App.TemplateCache = nil
+	_ = c.DB.SQL.Close()
+
+	s = status{}
+	code := get(c.Readyz, &s)
+	if code != http.StatusServiceUnavailable || s.Status != "unavailable" {
+		t.Errorf("got %d %+v, wanted 503 unavailable", code, s)
+	}
+	for _, name := range []string{"database", "templates", "mail"} {
+		if s.Checks[name] == "ok" || s.Checks[name] == "" {
+			t.Errorf("got %q for %s, wanted why it failed", s.Checks[name], name)
+		}
+	}
+	// the database error itself is only logged
+	if s.Checks["database"] != "database unavailable" {
+		t.Errorf("got %q for the database, wanted database unavailable", s.Checks["database"])
+	}
+}
+
+func TestChecker_Version(t *testing.T) {
+	Commit, BuildTime = "abc123", "2022-12-27T09:00:00Z"
+	defer func() { Commit, BuildTime = "", "" }()
+
+	var info BuildInfo
+	code := get(newTestChecker(t, true).Version, &info)
+	want := BuildInfo{Commit: "abc123", BuildTime: "2022-12-27T09:00:00Z", GoVersion: runtime.Version()}
+	if code != http.StatusOK || info != want {
+		t.Errorf("got %d %+v, wanted %+v", code, info, want)
+	}
+}
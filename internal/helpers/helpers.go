@@ -1,7 +1,8 @@
 package helpers
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/Seician/bookings/internal/config"
 	"net/http"
 	"runtime/debug"
@@ -14,14 +15,13 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(writer http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
+func ClientError(writer http.ResponseWriter, request *http.Request, status int) {
+	app.Logger.InfoContext(request.Context(), "client error", "status", status)
 	http.Error(writer, http.StatusText(status), status)
 }
 
-func ServerError(writer http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
+func ServerError(writer http.ResponseWriter, request *http.Request, err error) {
+	app.Logger.ErrorContext(request.Context(), "server error", "error", err, "stack", string(debug.Stack()))
 	http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
@@ -29,3 +29,13 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// NewToken returns a random, url safe token
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
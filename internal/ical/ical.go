@@ -0,0 +1,140 @@
+package ical
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of an iCalendar file
+const ContentType = "text/calendar; charset=utf-8"
+
+// DefaultProdID identifies the site as the producer of its calendars
+const DefaultProdID = "-//Seician//Bookings//EN"
+
+// Event is an all-day event, from the day of Start up to but not including the day of End,
+// like a stay from arrival to departure
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start       time.Time
+	End         time.Time
+}
+
+// Calendar is an iCalendar object (RFC 5545). Method is left out when empty, and Stamp, the time
+// the events are published at, defaults to now
+type Calendar struct {
+	ProdID string
+	Name   string
+	Method string
+	Stamp  time.Time
+	Events []Event
+}
+
+// Write writes the calendar to w as an .ics file
+func (c Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	prodID := c.ProdID
+	if prodID == "" {
+		prodID = DefaultProdID
+	}
+	stamp := c.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", escapeText(prodID))
+	line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		line("X-WR-CALNAME", escapeText(c.Name))
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escapeText(e.UID))
+		line("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+		line("DTSTART;VALUE=DATE", e.Start.Format("20060102"))
+		line("DTEND;VALUE=DATE", e.End.Format("20060102"))
+		line("SUMMARY", escapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeText(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", escapeText(e.Location))
+		}
+		if e.URL != "" {
+			line("URL", e.URL)
+		}
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+
+	return bw.Flush()
+}
+
+// escapeText escapes a TEXT value: backslashes, semicolons, commas and newlines
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeFolded writes a content line ending in CRLF, folding it so no line is longer than 75 octets
+// without splitting a UTF-8 character
+func writeFolded(w *bufio.Writer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = 74
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// FeedToken returns the token that unlocks the calendar feed of a room, signed with secret
+func FeedToken(secret string, roomID int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("room:" + strconv.Itoa(roomID)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// ValidFeedToken reports whether token unlocks the calendar feed of a room
+func ValidFeedToken(secret string, roomID int, token string) bool {
+	if secret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(FeedToken(secret, roomID)), []byte(token))
+}
+
+// UID returns a globally unique id for the event of a kind of record of the site at domain, like
+// reservation-12@example.com
+func UID(kind string, id int, domain string) string {
+	return fmt.Sprintf("%s-%d@%s", kind, id, domain)
+}
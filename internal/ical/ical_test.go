@@ -0,0 +1,178 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Write(t *testing.T) {
+	arrival := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := Calendar{
+		Name:   "Fort Smythe",
+		Method: "PUBLISH",
+		Stamp:  time.Date(2049, 12, 1, 9, 30, 0, 0, time.UTC),
+		Events: []Event{{
+			UID:         "reservation-1@localhost",
+			Summary:     "Stay at Fort Smythe; General's Quarters, sea view",
+			Description: "Line one\nline two",
+			Start:       arrival,
+			End:         arrival.AddDate(0, 0, 2),
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := c.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"METHOD:PUBLISH\r\n",
+		"DTSTAMP:20491201T093000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500103\r\n",
+		`SUMMARY:Stay at Fort Smythe\; General's Quarters\, sea view` + "\r\n",
+		`DESCRIPTION:Line one\nline two` + "\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("did not find %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "LOCATION") {
+		t.Error("empty location was written")
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	var buf bytes.Buffer
+	c := Calendar{Events: []Event{{UID: "1", Summary: strings.Repeat("é", 100)}}}
+	err := c.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unfolded strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long", i, len(line))
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+			continue
+		}
+		unfolded.WriteString("\n" + line)
+	}
+	if !strings.Contains(unfolded.String(), "SUMMARY:"+strings.Repeat("é", 100)+"\n") {
+		t.Error("folding lost or split characters")
+	}
+}
+
+func TestFeedToken(t *testing.T) {
+	token := FeedToken("secret", 1)
+	if len(token) != 32 {
+		t.Errorf("got a token of %d characters, wanted 32", len(token))
+	}
+
+	if !ValidFeedToken("secret", 1, token) {
+		t.Error("token of room 1 was refused")
+	}
+	if ValidFeedToken("secret", 2, token) {
+		t.Error("token of room 1 unlocked room 2")
+	}
+	if ValidFeedToken("other", 1, token) {
+		t.Error("token signed with another secret was accepted")
+	}
+	if ValidFeedToken("", 1, FeedToken("", 1)) {
+		t.Error("feeds are unlocked without a secret")
+	}
+}
+
+func TestParse(t *testing.T) {
+	feed := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Other Channel//EN",
+		"BEGIN:VEVENT",
+		"UID:all-day@other",
+		"DTSTART;VALUE=DATE:20500110",
+		"DTEND;VALUE=DATE:20500113",
+		`SUMMARY:Reserved\, by a`,
+		"  guest",
+		"BEGIN:VALARM",
+		"DESCRIPTION:not the event's",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:timed@other",
+		"DTSTART;TZID=\"America/New_York\":20500201T150000",
+		"DTEND;TZID=\"America/New_York\":20500203T110000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:utc@other",
+		"DTSTART:20500301T230000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:cancelled@other",
+		"DTSTART;VALUE=DATE:20500401",
+		"DTEND;VALUE=DATE:20500402",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:no-start@other",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	want := []Event{
+		{UID: "all-day@other", Summary: "Reserved, by a guest", Start: date(2050, 1, 10), End: date(2050, 1, 13)},
+		{UID: "timed@other", Start: date(2050, 2, 1), End: date(2050, 2, 4)},
+		{UID: "utc@other", Start: date(2050, 3, 1), End: date(2050, 3, 2)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, wanted %d: %+v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.UID != want[i].UID || e.Summary != want[i].Summary || e.Description != "" ||
+			!e.Start.Equal(want[i].Start) || !e.End.Equal(want[i].End) {
+			t.Errorf("event %d: got %+v, wanted %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	arrival := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	written := Event{UID: "reservation-1@localhost", Summary: "Reserved; sea view", Start: arrival, End: arrival.AddDate(0, 0, 2)}
+
+	var buf bytes.Buffer
+	err := Calendar{Events: []Event{written}}.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UID != written.UID || events[0].Summary != written.Summary ||
+		!events[0].Start.Equal(written.Start) || !events[0].End.Equal(written.End) {
+		t.Errorf("got %+v back, wanted %+v", events, written)
+	}
+}
+
+func TestParse_NotCalendar(t *testing.T) {
+	_, err := Parse(strings.NewReader("<html><body>Please log in: it's quick</body></html>"))
+	if err != ErrNotCalendar {
+		t.Errorf("got %v, wanted ErrNotCalendar", err)
+	}
+}
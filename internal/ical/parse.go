@@ -0,0 +1,228 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned when parsing something that isn't an iCalendar file, like the login
+// page a feed link redirects to once it has expired
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Parse reads the events of an iCalendar file as all-day events. Events with a time of day start
+// on the day they start and last until the end of the day they end, so they block every night
+// they touch. Cancelled events and events without a start are left out
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var components []string
+	var event *Event
+	var start, end property
+	var cancelled, calendar bool
+
+	for _, l := range lines {
+		p, ok := parseLine(l)
+		if !ok {
+			continue
+		}
+
+		switch p.name {
+		case "BEGIN":
+			component := strings.ToUpper(p.value)
+			components = append(components, component)
+			if component == "VCALENDAR" {
+				calendar = true
+			}
+			if component == "VEVENT" && len(components) == 2 {
+				event = &Event{}
+				start, end = property{}, property{}
+				cancelled = false
+			}
+			continue
+		case "END":
+			if len(components) > 0 {
+				components = components[:len(components)-1]
+			}
+			if event != nil && len(components) == 1 {
+				if e, ok := finishEvent(*event, start, end); ok && !cancelled {
+					events = append(events, e)
+				}
+				event = nil
+			}
+			continue
+		}
+
+		// properties of the alarms and other components nested in an event are not the event's
+		if event == nil || len(components) != 2 {
+			continue
+		}
+
+		switch p.name {
+		case "UID":
+			event.UID = p.value
+		case "SUMMARY":
+			event.Summary = unescapeText(p.value)
+		case "DESCRIPTION":
+			event.Description = unescapeText(p.value)
+		case "LOCATION":
+			event.Location = unescapeText(p.value)
+		case "URL":
+			event.URL = p.value
+		case "DTSTART":
+			start = p
+		case "DTEND":
+			end = p
+		case "STATUS":
+			cancelled = strings.EqualFold(p.value, "CANCELLED")
+		}
+	}
+
+	if !calendar {
+		return nil, ErrNotCalendar
+	}
+
+	return events, nil
+}
+
+// property is a content line, like DTSTART;TZID=Europe/Paris:20500101T150000
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfold reads the content lines of r, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its name, parameters and value. The value starts at the
+// first colon that isn't inside a quoted parameter
+func parseLine(l string) (property, bool) {
+	quoted := false
+	colon := -1
+	for i, c := range l {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 1 {
+		return property{}, false
+	}
+
+	p := property{params: map[string]string{}, value: l[colon+1:]}
+	parts := strings.Split(l[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		if k, v, ok := strings.Cut(param, "="); ok {
+			p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+
+	return p, true
+}
+
+// finishEvent sets the days of an event from its DTSTART and DTEND. An event without an end lasts
+// the day it starts, and one that ends before it starts is dropped
+func finishEvent(e Event, start, end property) (Event, bool) {
+	if start.value == "" {
+		return e, false
+	}
+
+	startTime, _, err := parseDate(start)
+	if err != nil {
+		return e, false
+	}
+	e.Start = day(startTime)
+
+	e.End = e.Start.AddDate(0, 0, 1)
+	if end.value != "" {
+		var endTime time.Time
+		var hasTime bool
+		endTime, hasTime, err = parseDate(end)
+		if err != nil {
+			return e, false
+		}
+		e.End = endTime
+		// a stay ending at 11:00 still takes that day up until then
+		if hasTime && !endTime.Equal(day(endTime)) {
+			e.End = endTime.AddDate(0, 0, 1)
+		}
+		e.End = day(e.End)
+	}
+
+	if !e.End.After(e.Start) {
+		// zero length events, like a DTEND equal to DTSTART, still take their day
+		if e.End.Equal(e.Start) {
+			e.End = e.Start.AddDate(0, 0, 1)
+		} else {
+			return e, false
+		}
+	}
+
+	return e, true
+}
+
+// parseDate reads a DATE or DATE-TIME value, in UTC, the zone of its TZID or floating. It returns
+// the start of the day for dates, and the wall clock time in the event's zone for date-times
+func parseDate(p property) (time.Time, bool, error) {
+	value := strings.TrimSpace(p.value)
+
+	if len(value) == 8 || p.params["VALUE"] == "DATE" {
+		t, err := time.Parse("20060102", value)
+		return t, false, err
+	}
+
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	t, err := time.ParseInLocation("20060102T150405Z", value, time.UTC)
+	if err != nil {
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+		if err != nil {
+			return t, true, err
+		}
+	}
+
+	// keep the wall clock of the zone the event is in, on a UTC date like the rest of the site
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC), true, nil
+}
+
+// day returns midnight at the start of the day of t
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
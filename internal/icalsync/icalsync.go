@@ -0,0 +1,173 @@
+package icalsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/ical"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"io"
+	"net/http"
+	"time"
+)
+
+// MaxFeedSize is the most the syncer reads of a calendar feed
+const MaxFeedSize = 5 << 20
+
+// maxErrorLength keeps the error recorded on a calendar within its column
+const maxErrorLength = 1000
+
+// Syncer imports the calendars rooms have on other booking channels as blocks, so a room taken
+// there can't be booked here
+type Syncer struct {
+	App    *config.AppConfig
+	DB     repository.DatabaseRepo
+	Client *http.Client
+}
+
+// New creates a syncer for the calendars in db
+func New(a *config.AppConfig, db repository.DatabaseRepo) *Syncer {
+	return &Syncer{
+		App:    a,
+		DB:     db,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Run syncs every calendar straight away and then every App.ICal.SyncInterval, until ctx is done
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.App.ICal.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs the calendars of every room, logging how each one went. A calendar that fails
+// keeps the blocks of its last sync until it works again
+func (s *Syncer) SyncAll(ctx context.Context) {
+	calendars, err := s.DB.AllRoomCalendars(ctx)
+	if err != nil {
+		s.App.Logger.Error("can't load room calendars", "error", err)
+		return
+	}
+
+	for _, cal := range calendars {
+		if ctx.Err() != nil {
+			return
+		}
+
+		result, err := s.SyncCalendar(ctx, cal)
+		if err != nil {
+			s.App.Logger.Error("can't sync room calendar", "calendar", cal.Name, "room_id", cal.RoomId, "error", err)
+			continue
+		}
+		if result != (models.CalendarSync{}) {
+			s.App.Logger.Info("synced room calendar", "calendar", cal.Name, "room_id", cal.RoomId,
+				"added", result.Added, "updated", result.Updated, "removed", result.Removed)
+		}
+	}
+}
+
+// SyncCalendar fetches a room calendar and makes its blocks match the events in it, recording on
+// the calendar when that worked or why it didn't
+func (s *Syncer) SyncCalendar(ctx context.Context, cal models.RoomCalendar) (models.CalendarSync, error) {
+	result, err := s.sync(ctx, cal)
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		if len(lastError) > maxErrorLength {
+			lastError = lastError[:maxErrorLength]
+		}
+	}
+
+	statusErr := s.DB.UpdateRoomCalendarStatus(ctx, cal.ID, lastError)
+	if err == nil {
+		err = statusErr
+	}
+
+	return result, err
+}
+
+// sync fetches and parses a room calendar and saves its events as blocks
+func (s *Syncer) sync(ctx context.Context, cal models.RoomCalendar) (models.CalendarSync, error) {
+	events, err := s.fetch(ctx, cal.URL)
+	if err != nil {
+		return models.CalendarSync{}, err
+	}
+
+	return s.DB.SyncRoomCalendar(ctx, cal, Blocks(events, time.Now()))
+}
+
+// fetch downloads and parses the calendar at url
+func (s *Syncer) fetch(ctx context.Context, url string) ([]ical.Event, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	req.Header.Set("User-Agent", "Bookings calendar sync")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %d MB", MaxFeedSize>>20)
+	}
+
+	return ical.Parse(bytes.NewReader(body))
+}
+
+// Blocks turns the events of a calendar into the blocks they take, leaving out stays that were over
+// before now. Events are told apart by their UID; one without a UID, or with the UID of another
+// event, is told apart by its dates instead
+func Blocks(events []ical.Event, now time.Time) []models.RoomRestriction {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var blocks []models.RoomRestriction
+	seen := make(map[string]bool)
+
+	for _, e := range events {
+		if !e.End.After(today) {
+			continue
+		}
+
+		uid := e.UID
+		if uid == "" || seen[uid] {
+			uid = fmt.Sprintf("%s/%s-%s", e.UID, e.Start.Format("20060102"), e.End.Format("20060102"))
+		}
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+
+		blocks = append(blocks, models.RoomRestriction{
+			StartDate:   e.Start,
+			EndDate:     e.End,
+			ExternalUID: uid,
+		})
+	}
+
+	return blocks
+}
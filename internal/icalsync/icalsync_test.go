@@ -0,0 +1,206 @@
+package icalsync
+
+import (
+	"context"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/driver"
+	"github.com/Seician/bookings/internal/ical"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository/dbrepo"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSyncer returns a syncer for a seeded sqlite database with a calendar for room 2 at url
+func newTestSyncer(t *testing.T, url string) (*Syncer, models.RoomCalendar) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.SQL.Close() })
+
+	if err = dbrepo.MigrateSQLite(db.SQL, "./../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if err = dbrepo.SeedSQLite(db.SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.AppConfig{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ICal:   config.ICalConfig{SyncInterval: time.Hour},
+	}
+	repo := dbrepo.NewSQLiteRepo(db.SQL, app)
+
+	err = repo.InsertRoomCalendar(context.Background(), models.RoomCalendar{RoomId: 2, Name: "Other Channel", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := repo.GetRoomCalendars(context.Background(), 2)
+	if err != nil || len(calendars) != 1 {
+		t.Fatalf("got %v and %v, wanted the calendar of room 2", calendars, err)
+	}
+
+	return New(app, repo), calendars[0]
+}
+
+// feedStub serves whatever calendar it was last given, or fails with status when it is set
+type feedStub struct {
+	mu     sync.Mutex
+	feed   string
+	status int
+}
+
+func (f *feedStub) set(status int, events ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+	f.feed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func (f *feedStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != http.StatusOK {
+		http.Error(w, "unavailable", f.status)
+		return
+	}
+	w.Header().Set("Content-Type", ical.ContentType)
+	_, _ = io.WriteString(w, f.feed)
+}
+
+func event(uid, start, end string) string {
+	return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART;VALUE=DATE:" + start + "\r\nDTEND;VALUE=DATE:" + end +
+		"\r\nSUMMARY:Reserved\r\nEND:VEVENT\r\n"
+}
+
+func TestSyncer_SyncCalendar(t *testing.T) {
+	stub := &feedStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	syncer, cal := newTestSyncer(t, server.URL+"/room.ics")
+	ctx := context.Background()
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+
+	stub.set(http.StatusOK, event("a@other", "20500110", "20500113"), event("b@other", "20500120", "20500122"))
+	result, err := syncer.SyncCalendar(ctx, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (models.CalendarSync{Added: 2}) {
+		t.Errorf("got %+v, wanted 2 added", result)
+	}
+
+	available, _ := syncer.DB.SearchAvailabilityByDatesByRoomId(ctx, start, start.AddDate(0, 0, 1), 2)
+	if available {
+		t.Error("room booked on the other channel shows as available")
+	}
+
+	// b is cancelled over there
+	stub.set(http.StatusOK, event("a@other", "20500110", "20500113"))
+	result, err = syncer.SyncCalendar(ctx, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (models.CalendarSync{Removed: 1}) {
+		t.Errorf("got %+v, wanted 1 removed", result)
+	}
+	available, _ = syncer.DB.SearchAvailabilityByDatesByRoomId(ctx, start.AddDate(0, 0, 10), start.AddDate(0, 0, 12), 2)
+	if !available {
+		t.Error("room is still blocked for a booking cancelled on the other channel")
+	}
+
+	// a failing feed keeps the blocks it had and records why
+	stub.set(http.StatusServiceUnavailable)
+	_, err = syncer.SyncCalendar(ctx, cal)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, wanted the status of the feed", err)
+	}
+	available, _ = syncer.DB.SearchAvailabilityByDatesByRoomId(ctx, start, start.AddDate(0, 0, 1), 2)
+	if available {
+		t.Error("a failed sync removed the blocks of the last one")
+	}
+	cal, _ = syncer.DB.GetRoomCalendarByID(ctx, cal.ID)
+	if !strings.Contains(cal.LastError, "503") || cal.LastSyncedAt.IsZero() {
+		t.Errorf("got %+v, wanted the error and the time of the last sync that worked", cal)
+	}
+}
+
+func TestSyncer_SyncCalendar_NotCalendar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "<html>Please log in</html>")
+	}))
+	defer server.Close()
+
+	syncer, cal := newTestSyncer(t, server.URL)
+	_, err := syncer.SyncCalendar(context.Background(), cal)
+	if err != ical.ErrNotCalendar {
+		t.Errorf("got %v, wanted ical.ErrNotCalendar", err)
+	}
+}
+
+func TestSyncer_Run(t *testing.T) {
+	stub := &feedStub{}
+	stub.set(http.StatusOK, event("a@other", "20500110", "20500113"))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	syncer, cal := newTestSyncer(t, server.URL)
+
+	// Run syncs straight away, then returns once it is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		syncer.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, _ := syncer.DB.GetRoomCalendarByID(context.Background(), cal.ID)
+		if !c.LastSyncedAt.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("calendar was not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once cancelled")
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	now := time.Date(2050, 1, 10, 15, 0, 0, 0, time.UTC)
+	date := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	blocks := Blocks([]ical.Event{
+		{UID: "over", Start: date(5), End: date(10)},
+		{UID: "leaving-today", Start: date(8), End: date(11)},
+		{UID: "repeated", Start: date(12), End: date(13)},
+		{UID: "repeated", Start: date(20), End: date(21)},
+		{UID: "repeated", Start: date(20), End: date(21)},
+		{Start: date(25), End: date(27)},
+	}, now)
+
+	var uids []string
+	for _, b := range blocks {
+		uids = append(uids, b.ExternalUID)
+	}
+	want := "leaving-today repeated repeated/20500120-20500121 /20500125-20500127"
+	if strings.Join(uids, " ") != want {
+		t.Errorf("got blocks %q, wanted %q", strings.Join(uids, " "), want)
+	}
+}
@@ -0,0 +1,193 @@
+package invoices
+
+import (
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/payments"
+	"github.com/Seician/bookings/internal/render"
+	"github.com/jung-kurt/gofpdf"
+	"io"
+	"math"
+	"strings"
+)
+
+// Document is everything printed on the invoice of a reservation. The amounts all come from
+// Invoice, the reservation only gives the guest and the stay
+type Document struct {
+	Property    config.PropertyConfig
+	Invoice     models.Invoice
+	Reservation models.Reservation
+}
+
+// Number formats an invoice number the way it's printed, like INV-000042
+func Number(n int) string {
+	return fmt.Sprintf("INV-%06d", n)
+}
+
+// Filename is the name an invoice is downloaded or attached as
+func Filename(inv models.Invoice) string {
+	return Number(inv.Number) + ".pdf"
+}
+
+// Tax returns the tax included in gross, in cents, at percent
+func Tax(gross int, percent float64) int {
+	if percent <= 0 {
+		return 0
+	}
+	net := math.Round(float64(gross) * 100 / (100 + percent))
+	return gross - int(net)
+}
+
+// Paid returns what the guest has paid so far: their deposit once it's been captured
+func Paid(res models.Reservation) int {
+	if res.PaymentStatus == payments.StatusCaptured {
+		return res.Deposit
+	}
+	return 0
+}
+
+// Draft returns the invoice res would be issued: the nights it was booked at, its discount and
+// what the guest has paid, taxed at taxPercent. A reservation booked before its nights were kept
+// gets one line for the whole stay
+func Draft(res models.Reservation, taxPercent float64) models.Invoice {
+	inv := models.Invoice{
+		ReservationId: res.ID,
+		Total:         res.TotalPrice,
+		TaxPercent:    taxPercent,
+		Tax:           Tax(res.TotalPrice, taxPercent),
+		Paid:          Paid(res),
+	}
+
+	for _, n := range res.Nights {
+		rate := n.Season
+		if rate == "" {
+			rate = "Standard"
+			if n.Weekend {
+				rate = "Weekend"
+			}
+		}
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Label: n.Date.Format("Mon, Jan 2 2006"), Detail: rate, Amount: n.Rate})
+	}
+	if len(inv.Lines) == 0 {
+		detail := "1 night"
+		if nights := int(res.EndDate.Sub(res.StartDate).Hours() / 24); nights != 1 {
+			detail = fmt.Sprintf("%d nights", nights)
+		}
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Label: "Stay", Detail: detail, Amount: res.TotalPrice + res.Discount})
+	}
+	if res.Discount > 0 {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Label: "Discount", Detail: "Promo code " + res.PromoCode, Amount: -res.Discount})
+	}
+
+	return inv
+}
+
+// Write writes the invoice as an A4 PDF to w
+func Write(w io.Writer, d Document) error {
+	res := d.Reservation
+	inv := d.Invoice
+	number := Number(inv.Number)
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Invoice "+number, false)
+	pdf.SetAuthor(d.Property.Name, true)
+	pdf.SetCreationDate(d.Invoice.CreatedAt)
+	pdf.SetModificationDate(d.Invoice.CreatedAt)
+	pdf.AddPage()
+
+	// the core fonts only know cp1252, so guest names with accents are translated to it
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	// the property, with the invoice details on the right
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(110, 8, tr(d.Property.Name), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 8, "Invoice", "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	details := []string{
+		number,
+		"Date: " + d.Invoice.CreatedAt.Format("Jan 2, 2006"),
+		fmt.Sprintf("Reservation: %d", res.ID),
+	}
+	var property []string
+	for _, line := range strings.Split(d.Property.Address, ",") {
+		if line = strings.TrimSpace(line); line != "" {
+			property = append(property, line)
+		}
+	}
+	if d.Property.Email != "" {
+		property = append(property, d.Property.Email)
+	}
+	if d.Property.TaxID != "" {
+		property = append(property, "Tax ID: "+d.Property.TaxID)
+	}
+	for i := 0; i < len(property) || i < len(details); i++ {
+		var left, right string
+		if i < len(property) {
+			left = property[i]
+		}
+		if i < len(details) {
+			right = details[i]
+		}
+		pdf.CellFormat(110, 5, tr(left), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, right, "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	// the guest and their stay
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(110, 5, "Bill to", "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 5, "Stay", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	guest := []string{res.FirstName + " " + res.LastName, res.Email, res.Phone}
+	stay := []string{
+		res.Room.RoomName,
+		"Arrival: " + res.StartDate.Format("Jan 2, 2006"),
+		"Departure: " + res.EndDate.Format("Jan 2, 2006"),
+	}
+	for i := range stay {
+		pdf.CellFormat(110, 5, tr(guest[i]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, tr(stay[i]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	// the nightly breakdown
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(235, 235, 235)
+	pdf.CellFormat(60, 7, "Night", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(80, 7, "Rate", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(0, 7, "Amount", "B", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, l := range inv.Lines {
+		pdf.CellFormat(60, 6, tr(l.Label), "", 0, "L", false, 0, "")
+		pdf.CellFormat(80, 6, tr(l.Detail), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, render.Money(l.Amount), "", 1, "R", false, 0, "")
+	}
+
+	// totals, taxes and payments
+	total := func(label string, amount int) {
+		pdf.CellFormat(140, 6, tr(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(0, 6, render.Money(amount), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+	pdf.SetFont("Helvetica", "B", 10)
+	total("Total", inv.Total)
+	pdf.SetFont("Helvetica", "", 10)
+	if inv.TaxPercent > 0 {
+		total("Net amount", inv.Total-inv.Tax)
+		total(fmt.Sprintf("Tax included at %g%%", inv.TaxPercent), inv.Tax)
+	}
+	if inv.Paid > 0 {
+		total("Deposit paid by card ("+res.PaymentId+")", -inv.Paid)
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	total("Balance due", inv.Total-inv.Paid)
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 5, tr("Thank you for staying with "+d.Property.Name+". The balance is due on arrival."), "", "L", false)
+
+	return pdf.Output(w)
+}
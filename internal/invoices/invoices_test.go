@@ -0,0 +1,139 @@
+package invoices
+
+import (
+	"bytes"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/payments"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNumber(t *testing.T) {
+	if got := Number(42); got != "INV-000042" {
+		t.Errorf("got %q, wanted INV-000042", got)
+	}
+	if got := Filename(models.Invoice{Number: 1234567}); got != "INV-1234567.pdf" {
+		t.Errorf("got %q, wanted INV-1234567.pdf", got)
+	}
+}
+
+func TestTax(t *testing.T) {
+	var tests = []struct {
+		gross    int
+		percent  float64
+		expected int
+	}{
+		{11000, 10, 1000},
+		{10750, 7.5, 750},
+		{9900, 0, 0},
+		{100, 20, 17},
+	}
+
+	for _, e := range tests {
+		if got := Tax(e.gross, e.percent); got != e.expected {
+			t.Errorf("tax at %g%% of %d: got %d, wanted %d", e.percent, e.gross, got, e.expected)
+		}
+	}
+}
+
+func TestPaid(t *testing.T) {
+	res := models.Reservation{Deposit: 3000, PaymentStatus: payments.StatusCaptured}
+	if got := Paid(res); got != 3000 {
+		t.Errorf("captured deposit: got %d paid, wanted 3000", got)
+	}
+
+	res.PaymentStatus = payments.StatusRefunded
+	if got := Paid(res); got != 0 {
+		t.Errorf("refunded deposit: got %d paid, wanted 0", got)
+	}
+}
+
+func TestDraft(t *testing.T) {
+	arrival := time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)
+	res := models.Reservation{
+		ID:         12,
+		StartDate:  arrival,
+		EndDate:    arrival.AddDate(0, 0, 2),
+		TotalPrice: 27000,
+		PromoCode:  "SUMMER10",
+		Discount:   3000,
+		Nights: []models.ReservationNight{
+			{Date: arrival, Rate: 15000, Weekend: true},
+			{Date: arrival.AddDate(0, 0, 1), Rate: 15000, Season: "Summer"},
+		},
+		Deposit:       5400,
+		PaymentStatus: payments.StatusCaptured,
+	}
+
+	inv := Draft(res, 10)
+	expected := []models.InvoiceLine{
+		{Label: "Fri, Jan 7 2050", Detail: "Weekend", Amount: 15000},
+		{Label: "Sat, Jan 8 2050", Detail: "Summer", Amount: 15000},
+		{Label: "Discount", Detail: "Promo code SUMMER10", Amount: -3000},
+	}
+	if !reflect.DeepEqual(inv.Lines, expected) {
+		t.Errorf("got lines %+v, wanted %+v", inv.Lines, expected)
+	}
+	if inv.ReservationId != 12 || inv.Total != 27000 || inv.Tax != 2455 || inv.Paid != 5400 {
+		t.Errorf("got reservation %d, total %d, tax %d and paid %d", inv.ReservationId, inv.Total, inv.Tax, inv.Paid)
+	}
+
+	// booked before the nights were kept, the stay is one line at the price it was booked at
+	res.Nights = nil
+	inv = Draft(res, 10)
+	expected = []models.InvoiceLine{
+		{Label: "Stay", Detail: "2 nights", Amount: 30000},
+		{Label: "Discount", Detail: "Promo code SUMMER10", Amount: -3000},
+	}
+	if !reflect.DeepEqual(inv.Lines, expected) {
+		t.Errorf("got lines %+v, wanted %+v", inv.Lines, expected)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	arrival := time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)
+	d := Document{
+		Property: config.PropertyConfig{
+			Name:    "Fort Smythe Bed and Breakfast",
+			Address: "1 Harbour Road, Fort Smythe",
+			TaxID:   "FS-123",
+		},
+		Invoice: models.Invoice{
+			Number: 7,
+			Lines: []models.InvoiceLine{
+				{Label: "Fri, Jan 7 2050", Detail: "Weekend", Amount: 15000},
+				{Label: "Sat, Jan 8 2050", Detail: "Weekend", Amount: 15000},
+				{Label: "Discount", Detail: "Promo code SUMMER10", Amount: -3000},
+			},
+			Total:      27000,
+			TaxPercent: 10,
+			Tax:        2455,
+			Paid:       5400,
+			CreatedAt:  arrival.AddDate(0, 0, -30),
+		},
+		Reservation: models.Reservation{
+			ID:        12,
+			FirstName: "Zoë",
+			LastName:  "Smith",
+			StartDate: arrival,
+			EndDate:   arrival.AddDate(0, 0, 2),
+			Room:      models.Room{RoomName: "General's Quarters"},
+			PaymentId: "fake_1",
+		},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) || !bytes.Contains(buf.Bytes(), []byte("%%EOF")) {
+		t.Error("output is not a PDF document")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("Invoice INV-000007")) {
+		t.Error("PDF title is not the invoice number")
+	}
+}
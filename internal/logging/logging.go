@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the id of a request, taken from the proxy in front when it sets one and
+// sent back with the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps a request id from the outside from filling the logs
+const maxRequestIDLength = 64
+
+type contextKey struct{}
+
+// requestInfo is what the logs know about the request being served. The middleware puts it in the
+// request context up front, so the user id set further in is seen when the request is logged
+type requestInfo struct {
+	id     string
+	userID int
+}
+
+// New returns a logger writing to w from level up: JSON for a log collector in production, text
+// for people otherwise. Records logged with a request context carry its request and user ids
+func New(w io.Writer, production bool, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	if production {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(contextHandler{h})
+}
+
+// contextHandler adds the ids of the request in the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		r.AddAttrs(slog.String("request_id", info.id))
+		if info.userID != 0 {
+			r.AddAttrs(slog.Int("user_id", info.userID))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestID returns the id of the request ctx belongs to, or "" outside a request
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetUserID records the user the request ctx belongs to is logged in as
+func SetUserID(ctx context.Context, id int) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.userID = id
+	}
+}
+
+// Middleware gives every request an id and logs it once served, with the route it matched, its
+// status and how long it took. Requests to the quiet paths, such as the probes an orchestrator
+// polls, are logged at debug level only
+func Middleware(logger *slog.Logger, quiet ...string) func(http.Handler) http.Handler {
+	isQuiet := make(map[string]bool)
+	for _, p := range quiet {
+		isQuiet[p] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+
+			info := &requestInfo{id: request.Header.Get(RequestIDHeader)}
+			if info.id == "" || len(info.id) > maxRequestIDLength {
+				info.id = newRequestID()
+			}
+			writer.Header().Set(RequestIDHeader, info.id)
+			ctx := context.WithValue(request.Context(), contextKey{}, info)
+
+			ww := middleware.NewWrapResponseWriter(writer, request.ProtoMajor)
+			next.ServeHTTP(ww, request.WithContext(ctx))
+
+			route := ""
+			if rctx := chi.RouteContext(ctx); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case isQuiet[request.URL.Path]:
+				level = slog.LevelDebug
+			}
+
+			logger.LogAttrs(ctx, level, "request",
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+			)
+		})
+	}
+}
+
+// newRequestID returns a random id for a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, true, slog.LevelInfo).Info("hello", "room_id", 1)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("production logs are not JSON: %v", err)
+	}
+	if record["msg"] != "hello" || record["room_id"] != float64(1) {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	buf.Reset()
+	New(&buf, false, slog.LevelInfo).Info("hello")
+	if !strings.Contains(buf.String(), "msg=hello") {
+		t.Errorf("development logs are not text: %s", buf.String())
+	}
+
+	buf.Reset()
+	New(&buf, false, slog.LevelWarn).Info("hello")
+	if buf.Len() != 0 {
+		t.Errorf("logged below the level: %s", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("got request id %q outside a request", id)
+	}
+	// no request to record the user on
+	SetUserID(context.Background(), 1)
+}
+
+func serve(header string, status int, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var buf bytes.Buffer
+	logger := New(&buf, true, slog.LevelInfo)
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware(logger, "/healthz"))
+	mux.Get("/rooms/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		SetUserID(request.Context(), 7)
+		logger.InfoContext(request.Context(), "handled")
+		writer.WriteHeader(status)
+	})
+	mux.Get("/healthz", func(writer http.ResponseWriter, request *http.Request) {})
+
+	request := httptest.NewRequest("GET", path, nil)
+	if header != "" {
+		request.Header.Set(RequestIDHeader, header)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, request)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+		return rr, nil
+	}
+	return rr, record
+}
+
+func TestMiddleware(t *testing.T) {
+	rr, record := serve("abc", http.StatusOK, "/rooms/1")
+
+	if got := rr.Header().Get(RequestIDHeader); got != "abc" {
+		t.Errorf("got request id header %q, wanted abc", got)
+	}
+	for key, want := range map[string]interface{}{
+		"msg":        "request",
+		"level":      "INFO",
+		"request_id": "abc",
+		"user_id":    float64(7),
+		"route":      "/rooms/{id}",
+		"path":       "/rooms/1",
+		"status":     float64(http.StatusOK),
+	} {
+		if record[key] != want {
+			t.Errorf("%s: got %v, wanted %v", key, record[key], want)
+		}
+	}
+}
+
+func TestMiddleware_RequestID(t *testing.T) {
+	rr, record := serve("", http.StatusOK, "/rooms/1")
+	id := rr.Header().Get(RequestIDHeader)
+	if id == "" || record["request_id"] != id {
+		t.Errorf("got request id %q in the header and %v in the log", id, record["request_id"])
+	}
+
+	rr, _ = serve(strings.Repeat("a", maxRequestIDLength+1), http.StatusOK, "/rooms/1")
+	if got := rr.Header().Get(RequestIDHeader); len(got) > maxRequestIDLength {
+		t.Errorf("kept an overlong request id %q", got)
+	}
+}
+
+func TestMiddleware_Level(t *testing.T) {
+	_, record := serve("", http.StatusInternalServerError, "/rooms/1")
+	if record["level"] != "ERROR" {
+		t.Errorf("server error logged at %v", record["level"])
+	}
+
+	// quiet paths are logged at debug level, below the info level of the logger
+	_, record = serve("", http.StatusOK, "/healthz")
+	if record != nil {
+		t.Errorf("quiet path logged: %v", record)
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/Seician/bookings/internal/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// File writes every email to a .eml file in Dir instead of sending it, for development. The
+// files open in any mail program
+type File struct {
+	Dir  string
+	next int64
+}
+
+// NewFile returns a mailer that writes to dir, creating it if needed
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Dir: dir}, nil
+}
+
+// Send writes msg to a new file named after the time it was sent
+func (f *File) Send(msg models.MailData) error {
+	email := newEmail(msg)
+	if err := email.GetError(); err != nil {
+		return err
+	}
+
+	n := atomic.AddInt64(&f.next, 1)
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102-150405.000"), n)
+
+	return os.WriteFile(filepath.Join(f.Dir, name), []byte(email.GetMessage()), 0644)
+}
@@ -0,0 +1,17 @@
+package mailer
+
+import (
+	"github.com/Seician/bookings/internal/models"
+	"log/slog"
+)
+
+// Log writes who each email is for and what it is about to Logger instead of sending it
+type Log struct {
+	Logger *slog.Logger
+}
+
+// Send logs msg
+func (l *Log) Send(msg models.MailData) error {
+	l.Logger.Info("mail", "to", msg.To, "from", msg.From, "subject", msg.Subject, "attachments", len(msg.Attachments))
+	return nil
+}
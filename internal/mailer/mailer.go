@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer sends an email, returning why it couldn't
+type Mailer interface {
+	Send(msg models.MailData) error
+}
+
+// New returns the mailer a.Mail.Backend asks for
+func New(a *config.AppConfig) (Mailer, error) {
+	switch a.Mail.Backend {
+	case "smtp":
+		return NewSMTP(a.SMTP)
+	case "file":
+		return NewFile(a.Mail.Dir)
+	case "log":
+		return &Log{Logger: a.Logger}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", a.Mail.Backend)
+	}
+}
+
+// newEmail builds the message for msg, with its plain text alternative and attachments
+func newEmail(msg models.MailData) *mail.Email {
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+	if msg.PlainContent != "" {
+		email.SetBody(mail.TextPlain, msg.PlainContent)
+		email.AddAlternative(mail.TextHTML, msg.Content)
+	} else {
+		email.SetBody(mail.TextHTML, msg.Content)
+	}
+	for _, a := range msg.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Data})
+	}
+	return email
+}
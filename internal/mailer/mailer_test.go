@@ -0,0 +1,177 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
+	"log/slog"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMessage = models.MailData{
+	To:           "john@smith.com",
+	From:         "bookings@example.com",
+	Subject:      "Reservation confirmation",
+	Content:      "<p>See you soon</p>",
+	PlainContent: "See you soon",
+	Attachments:  []models.MailAttachment{{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}},
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		backend  string
+		expected string
+	}{
+		{"smtp", "*mailer.SMTP"},
+		{"file", "*mailer.File"},
+		{"log", "*mailer.Log"},
+		{"pigeon", ""},
+	}
+
+	for _, e := range tests {
+		a := &config.AppConfig{
+			SMTP: config.SMTPConfig{Host: "localhost", Port: 1025},
+			Mail: config.MailConfig{Backend: e.backend, Dir: t.TempDir()},
+		}
+		m, err := New(a)
+		if e.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.backend)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", e.backend, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", m); got != e.expected {
+			t.Errorf("%s: got %s, wanted %s", e.backend, got, e.expected)
+		}
+	}
+}
+
+func TestSMTP_Send_Unreachable(t *testing.T) {
+	// a port nothing listens on any more
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	s, err := NewSMTP(config.SMTPConfig{Host: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send(testMessage); err == nil {
+		t.Error("expected an error sending to a server that isn't there")
+	}
+}
+
+func TestSMTP_DKIM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err = os.WriteFile(keyFile, block, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSMTP(config.SMTPConfig{
+		Host:         "localhost",
+		Port:         1025,
+		DKIMDomain:   "example.com",
+		DKIMSelector: "bookings",
+		DKIMKeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := s.message(testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(email.DkimMsg, "DKIM-Signature:") || !strings.Contains(email.DkimMsg, "d=example.com") ||
+		!strings.Contains(email.DkimMsg, "s=bookings") {
+		t.Errorf("message is not signed for example.com: %.200s", email.DkimMsg)
+	}
+
+	_, err = NewSMTP(config.SMTPConfig{DKIMDomain: "example.com", DKIMSelector: "bookings", DKIMKeyFile: keyFile + ".missing"})
+	if err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
+
+func TestFile_Send(t *testing.T) {
+	f, err := NewFile(filepath.Join(t.TempDir(), "mail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = f.Send(testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(f.Dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("got files %v, wanted 2 .eml files", files)
+	}
+
+	content, _ := os.ReadFile(files[0])
+	msg, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("To") != "<john@smith.com>" || msg.Header.Get("Subject") != "Reservation confirmation" {
+		t.Errorf("got headers %v", msg.Header)
+	}
+	if !strings.Contains(string(content), "invoice.pdf") {
+		t.Error("the attachment is missing")
+	}
+}
+
+func TestLog_Send(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Log{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	if err := l.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "john@smith.com") || !strings.Contains(buf.String(), "Reservation confirmation") {
+		t.Errorf("got log %q", buf.String())
+	}
+}
+
+func TestMemory_Send(t *testing.T) {
+	m := &Memory{}
+	_ = m.Send(testMessage)
+
+	m.SetErr(errors.New("connection refused"))
+	if err := m.Send(testMessage); err == nil {
+		t.Error("expected the error given to SetErr")
+	}
+
+	m.SetErr(nil)
+	_ = m.Send(models.MailData{To: "jane@smith.com"})
+
+	sent := m.Messages()
+	if len(sent) != 2 || sent[0].To != "john@smith.com" || sent[1].To != "jane@smith.com" {
+		t.Errorf("got %+v, wanted the 2 emails that didn't fail", sent)
+	}
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"github.com/Seician/bookings/internal/models"
+	"sync"
+)
+
+// Memory keeps the emails it is given so tests can look at them, or fails them after SetErr
+type Memory struct {
+	mu       sync.Mutex
+	messages []models.MailData
+	err      error
+}
+
+// Send keeps msg, or fails with the error given to SetErr
+func (m *Memory) Send(msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the emails sent so far, oldest first
+func (m *Memory) Messages() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.messages...)
+}
+
+// SetErr makes the next emails fail with err, or be kept again when err is nil
+func (m *Memory) SetErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/toorop/go-dkim"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"os"
+	"time"
+)
+
+// SMTP sends emails through a mail server, connecting for each one
+type SMTP struct {
+	server *mail.SMTPServer
+	dkim   *dkim.SigOptions
+}
+
+// NewSMTP returns a mailer for the server in c, reading its DKIM key when mail is to be signed
+func NewSMTP(c config.SMTPConfig) (*SMTP, error) {
+	server := mail.NewSMTPClient()
+	server.Host = c.Host
+	server.Port = c.Port
+	server.Username = c.Username
+	server.Password = c.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	switch c.Encryption {
+	case "starttls":
+		server.Encryption = mail.EncryptionSTARTTLS
+	case "tls":
+		server.Encryption = mail.EncryptionSSLTLS
+	default:
+		server.Encryption = mail.EncryptionNone
+	}
+
+	switch {
+	case c.Username == "":
+		server.Authentication = mail.AuthNone
+	case c.Auth == "login":
+		server.Authentication = mail.AuthLogin
+	case c.Auth == "crammd5":
+		server.Authentication = mail.AuthCRAMMD5
+	default:
+		server.Authentication = mail.AuthPlain
+	}
+
+	s := &SMTP{server: server}
+
+	if c.DKIMKeyFile != "" {
+		key, err := os.ReadFile(c.DKIMKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the dkim key: %w", err)
+		}
+		options := dkim.NewSigOptions()
+		options.PrivateKey = key
+		options.Domain = c.DKIMDomain
+		options.Selector = c.DKIMSelector
+		options.Canonicalization = "relaxed/relaxed"
+		options.Headers = []string{"from", "to", "subject", "date", "mime-version", "content-type"}
+		s.dkim = &options
+	}
+
+	return s, nil
+}
+
+// Send signs msg when DKIM is set up and hands it to the server
+func (s *SMTP) Send(msg models.MailData) error {
+	email, err := s.message(msg)
+	if err != nil {
+		return err
+	}
+
+	// without KeepAlive the client quits once the email is sent
+	client, err := s.server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
+
+// message builds the email for msg, signed when DKIM is set up
+func (s *SMTP) message(msg models.MailData) (*mail.Email, error) {
+	email := newEmail(msg)
+	if s.dkim != nil {
+		email.SetDkim(*s.dkim)
+	}
+	return email, email.GetError()
+}
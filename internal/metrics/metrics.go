@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// namespace prefixes every metric of the application
+const namespace = "bookings"
+
+// MailCounter returns how many emails the outbox holds in each status
+type MailCounter func(ctx context.Context) (map[string]int, error)
+
+// Metrics holds what the application measures about itself, and serves it in the Prometheus
+// text format. Business counters are incremented by the handlers that do the work
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	handler  http.Handler
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	ReservationsCreated  prometheus.Counter
+	AvailabilitySearches prometheus.Counter
+	NoAvailability       prometheus.Counter
+	MailDeliveries       *prometheus.CounterVec
+}
+
+// New creates the metrics of the application in a registry of their own, along with the Go
+// runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		ReservationsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reservations_created_total",
+			Help:      "Reservations made by guests.",
+		}),
+		AvailabilitySearches: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "availability_searches_total",
+			Help:      "Searches for available rooms, for any room or for one.",
+		}),
+		NoAvailability: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "availability_searches_empty_total",
+			Help:      "Searches for available rooms that found none.",
+		}),
+		MailDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mail_deliveries_total",
+			Help:      "Attempts at sending an email from the outbox, by result: sent or failed.",
+		}, []string{"result"}),
+	}
+
+	m.handler = promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.ReservationsCreated,
+		m.AvailabilitySearches,
+		m.NoAvailability,
+		m.MailDeliveries,
+	)
+
+	return m
+}
+
+// RegisterDB adds the connection pool statistics of db
+func (m *Metrics) RegisterDB(db *sql.DB) error {
+	return m.Registry.Register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// RegisterMailQueue adds how many emails the outbox holds in each status, counted when scraped
+func (m *Metrics) RegisterMailQueue(count MailCounter) error {
+	return m.Registry.Register(&mailQueueCollector{
+		count: count,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "mail", "queue_messages"),
+			"Emails in the outbox, by status.",
+			[]string{"status"}, nil,
+		),
+	})
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (m *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	m.handler.ServeHTTP(writer, request)
+}
+
+// Middleware counts and times the requests next serves by the route pattern they matched, so
+// /rooms/{slug} is one series whatever the slug
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(writer, request.ProtoMajor)
+
+		next.ServeHTTP(ww, request)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(request.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		m.requests.WithLabelValues(request.Method, route, strconv.Itoa(code)).Inc()
+		m.duration.WithLabelValues(request.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// mailQueueCollector counts the emails in the outbox whenever the metrics are scraped
+type mailQueueCollector struct {
+	count MailCounter
+	desc  *prometheus.Desc
+}
+
+func (c *mailQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *mailQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for _, status := range models.MailStatuses {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(counts[status]), status)
+	}
+}
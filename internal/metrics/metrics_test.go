@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/Seician/bookings/internal/driver"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_RegisterMailQueue(t *testing.T) {
+	m := New()
+	var countErr error
+	err := m.RegisterMailQueue(func(ctx context.Context) (map[string]int, error) {
+		return map[string]int{"pending": 3, "failed": 1}, countErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP bookings_mail_queue_messages Emails in the outbox, by status.
+# TYPE bookings_mail_queue_messages gauge
+bookings_mail_queue_messages{status="failed"} 1
+bookings_mail_queue_messages{status="pending"} 3
+bookings_mail_queue_messages{status="sending"} 0
+bookings_mail_queue_messages{status="sent"} 0
+`
+	err = testutil.GatherAndCompare(m.Registry, strings.NewReader(expected), "bookings_mail_queue_messages")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// a count that fails is reported rather than shown as an empty queue
+	countErr = errors.New("database is down")
+	if _, err = m.Registry.Gather(); err == nil || !strings.Contains(err.Error(), "database is down") {
+		t.Errorf("got %v, wanted the error counting the queue", err)
+	}
+}
+
+func TestMetrics_RegisterDB(t *testing.T) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	m := New()
+	if err = m.RegisterDB(db.SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := testutil.GatherAndCount(m.Registry, "go_sql_max_open_connections")
+	if err != nil || n != 1 {
+		t.Errorf("got %d series and %v, wanted the pool's max open connections", n, err)
+	}
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := New()
+	m.ReservationsCreated.Inc()
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "bookings_reservations_created_total 1") {
+		t.Errorf("got %d and %q, wanted the reservations created", rr.Code, rr.Body.String())
+	}
+}
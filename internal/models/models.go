@@ -18,12 +18,119 @@ type User struct {
 
 // Room is the room model
 type Room struct {
+	ID          int
+	RoomName    string
+	Slug        string
+	Description string
+	Amenities   []string
+	Capacity    int
+	Active      bool
+	BaseRate    int
+	WeekendRate int
+	Photos      []RoomPhoto
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RoomRate is a seasonal price for a room, for the nights from StartDate to EndDate inclusive.
+// Rates are in cents
+type RoomRate struct {
+	ID          int
+	RoomId      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	WeekendRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RoomPhoto is a picture of a room, from static/images
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomId    int
+	FileName  string
+	Caption   string
+	Position  int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Promo code discount types
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// PromoCode is a code guests enter when booking to get a discount. Amount is a percentage for
+// DiscountPercent codes and cents for DiscountFixed ones. The code applies to stays arriving from
+// StartDate to EndDate inclusive, in any room when RoomIds is empty, and may be redeemed any number
+// of times when MaxRedemptions is zero. Redemptions counts the bookings using it that are not cancelled
+type PromoCode struct {
+	ID             int
+	Code           string
+	Description    string
+	DiscountType   string
+	Amount         int
+	StartDate      time.Time
+	EndDate        time.Time
+	MinNights      int
+	MaxRedemptions int
+	Redemptions    int
+	RoomIds        []int
+	Active         bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Restriction ids, matching the rows of the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionMaintenance = 3
+	RestrictionClosure     = 4
+	RestrictionExternal    = 5
+)
+
+// Reservation statuses
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCancelled  = "cancelled"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationStatuses lists every reservation status, in the order a stay goes through them
+var ReservationStatuses = []string{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationNoShow,
+	ReservationCancelled,
+}
+
+// ReservationTransitions lists the statuses a reservation may move to from each status;
+// checked-out, no-show and cancelled are final
+var ReservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationNoShow, ReservationCancelled},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// CanChangeStatus reports whether a reservation in status from may be moved to status to
+func CanChangeStatus(from, to string) bool {
+	for _, s := range ReservationTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
 // Restriction is the room model
 type Restriction struct {
 	ID              int
@@ -34,38 +141,164 @@ type Restriction struct {
 
 // Reservation s the reservations model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomId    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
-}
-
-// RoomRestriction is the room restrictions model
-type RoomRestriction struct {
 	ID            int
+	FirstName     string
+	LastName      string
+	Email         string
+	Phone         string
 	StartDate     time.Time
 	EndDate       time.Time
 	RoomId        int
-	ReservationId int
-	RestrictionId int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+	Processed     int
+	Status        string
+	CancelToken   string
+	TotalPrice    int
+	PromoCodeId   int
+	PromoCode     string
+	Discount      int
+	Deposit       int
+	PaymentId     string
+	PaymentStatus string
+	Nights        []ReservationNight
+}
+
+// ReservationNight is the price of one night of a reservation, as it was quoted when booking
+type ReservationNight struct {
+	Date    time.Time
+	Rate    int
+	Weekend bool
+	Season  string
+}
+
+// Invoice is the invoice of a reservation. Numbers follow each other without gaps, in the order
+// invoices were first issued. The lines and totals are kept as they were when it was issued, so
+// the invoice prints the same however the reservation or the rates change later
+type Invoice struct {
+	ID            int
+	ReservationId int
+	Number        int
+	Lines         []InvoiceLine
+	Total         int
+	TaxPercent    float64
+	Tax           int
+	Paid          int
+	CreatedAt     time.Time
+}
+
+// InvoiceLine is a line of an invoice, like a night of the stay or a discount
+type InvoiceLine struct {
+	Label  string
+	Detail string
+	Amount int
+}
+
+// ReservationFilter narrows down, orders and pages the admin reservations list
+type ReservationFilter struct {
+	From       time.Time
+	To         time.Time
+	RoomId     int
+	Status     string
+	Guest      string
+	Sort       string
+	Descending bool
+	Page       int
+	PerPage    int
+}
+
+// RoomRestriction is the room restrictions model. Blocks imported from a room calendar carry its
+// RoomCalendarId and the ExternalUID of the event they came from
+type RoomRestriction struct {
+	ID             int
+	StartDate      time.Time
+	EndDate        time.Time
+	RoomId         int
+	ReservationId  int
+	RestrictionId  int
+	RoomCalendarId int
+	ExternalUID    string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+	Reservation    Reservation
+	Restriction    Restriction
 }
 
-// MailData holds an email message
+// RoomCalendar is the iCal feed of a room on another booking channel. Its events are imported as
+// blocks; LastError is empty when the last sync worked
+type RoomCalendar struct {
+	ID           int
+	RoomId       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// CalendarSync counts the blocks a room calendar sync added, moved and removed
+type CalendarSync struct {
+	Added   int
+	Updated int
+	Removed int
+}
+
+// MailData holds an email message. Content is the HTML body and PlainContent its plain text
+// alternative, if there is one
 type MailData struct {
-	To      string
-	From    string
-	Subject string
-	Content string
+	To           string
+	From         string
+	Subject      string
+	Content      string
+	PlainContent string
+	Attachments  []MailAttachment
+}
+
+// MailAttachment is a file sent along with an email
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Mail outbox statuses. Messages wait as pending until a worker takes them for sending, and go back
+// to pending for a retry when sending fails, until they are sent or have failed too often
+const (
+	MailPending = "pending"
+	MailSending = "sending"
+	MailSent    = "sent"
+	MailFailed  = "failed"
+)
+
+// MailStatuses lists every mail outbox status
+var MailStatuses = []string{MailPending, MailSending, MailSent, MailFailed}
+
+// MailMessage is an email in the outbox. Attempts counts every attempt at sending it, resends
+// included, and AttemptsBeforeResend those made before it was last resent. NextAttemptAt is when
+// a pending message is due to be sent, and Deliveries the log of every attempt, oldest first
+type MailMessage struct {
+	ID                   int
+	Mail                 MailData
+	Status               string
+	Attempts             int
+	AttemptsBeforeResend int
+	NextAttemptAt        time.Time
+	LastError            string
+	SentAt               time.Time
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Deliveries           []MailDelivery
+}
+
+// MailDelivery records one attempt at sending a message, with the error when it failed
+type MailDelivery struct {
+	ID        int
+	MailId    int
+	Attempt   int
+	Status    string
+	Error     string
+	CreatedAt time.Time
 }
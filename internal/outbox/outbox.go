@@ -0,0 +1,175 @@
+package outbox
+
+import (
+	"context"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/mailer"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxErrorLength keeps the error recorded on a message within its column
+const maxErrorLength = 1000
+
+// Outbox queues emails in the database and sends them from a pool of workers, retrying those that
+// fail with a growing delay. Messages survive restarts, and sending never holds up a request
+type Outbox struct {
+	App          *config.AppConfig
+	DB           repository.DatabaseRepo
+	Mailer       mailer.Mailer
+	PollInterval time.Duration
+	wake         chan struct{}
+	running      int32
+}
+
+// New creates an outbox that sends its messages with m
+func New(a *config.AppConfig, db repository.DatabaseRepo, m mailer.Mailer) *Outbox {
+	return &Outbox{
+		App:          a,
+		DB:           db,
+		Mailer:       m,
+		PollInterval: 30 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue saves a message to the outbox and wakes a worker to send it
+func (o *Outbox) Enqueue(ctx context.Context, msg models.MailData) error {
+	_, err := o.DB.InsertMail(ctx, msg)
+	if err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// notify wakes a waiting worker, if one isn't about to wake already
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends messages from App.Mail.Workers workers until ctx is done, then waits for the workers
+// to finish the messages they are sending
+func (o *Outbox) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < o.App.Mail.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atomic.AddInt32(&o.running, 1)
+			defer atomic.AddInt32(&o.running, -1)
+			o.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Running reports whether any worker is sending
+func (o *Outbox) Running() bool {
+	return atomic.LoadInt32(&o.running) > 0
+}
+
+// Flush sends the messages that are due, one after the other, until there are none left or ctx is
+// done. It is meant for once Run has returned, so what was queued last doesn't wait for a restart
+func (o *Outbox) Flush(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		messages, err := o.DB.ClaimMail(ctx, 1)
+		if err != nil {
+			return err
+		}
+		// one that fails is due again later, so it isn't claimed twice
+		if len(messages) == 0 {
+			return nil
+		}
+		o.deliver(messages[0])
+	}
+}
+
+// work sends the messages that are due one at a time, waiting to be woken up or for the next poll
+// when there are none
+func (o *Outbox) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := o.DB.ClaimMail(ctx, 1)
+		if err != nil && ctx.Err() == nil {
+			o.App.Logger.Error("can't claim mail", "error", err)
+		}
+
+		if len(messages) > 0 {
+			// there may be more, so let another worker have a look
+			o.notify()
+			o.deliver(messages[0])
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-o.wake:
+		case <-time.After(o.PollInterval):
+		}
+	}
+}
+
+// deliver sends a message and records how it went, scheduling another attempt when it failed
+// and it has attempts left. A resent message gets as many attempts as a new one
+func (o *Outbox) deliver(msg models.MailMessage) {
+	// the outcome is recorded even when the outbox is stopping meanwhile
+	ctx := context.Background()
+	attempt := msg.Attempts - msg.AttemptsBeforeResend + 1
+
+	sendErr := o.Mailer.Send(msg.Mail)
+
+	result := "sent"
+	if sendErr != nil {
+		result = "failed"
+	}
+	o.App.Metrics.MailDeliveries.WithLabelValues(result).Inc()
+
+	var err error
+	switch {
+	case sendErr == nil:
+		o.App.Logger.Info("sent mail", "mail_id", msg.ID)
+		err = o.DB.RecordMailAttempt(ctx, msg.ID, models.MailSent, "", time.Time{})
+	case attempt >= o.App.Mail.MaxAttempts:
+		o.App.Logger.Error("gave up sending mail", "mail_id", msg.ID, "attempts", attempt, "error", sendErr)
+		err = o.DB.RecordMailAttempt(ctx, msg.ID, models.MailFailed, errorMessage(sendErr), time.Time{})
+	default:
+		retry := Backoff(attempt)
+		o.App.Logger.Warn("can't send mail, retrying", "mail_id", msg.ID, "retry_in", retry, "error", sendErr)
+		err = o.DB.RecordMailAttempt(ctx, msg.ID, models.MailPending, errorMessage(sendErr), time.Now().Add(retry))
+	}
+	if err != nil {
+		o.App.Logger.Error("can't record the delivery of mail", "mail_id", msg.ID, "error", err)
+	}
+}
+
+// Backoff returns how long to wait before trying a message again after its attempt-th attempt
+// failed: a minute after the first, doubling every time up to six hours
+func Backoff(attempt int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempt && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// errorMessage returns the error of a failed attempt, cut to fit its column
+func errorMessage(err error) string {
+	s := err.Error()
+	if len(s) > maxErrorLength {
+		s = s[:maxErrorLength]
+	}
+	return s
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/driver"
+	"github.com/Seician/bookings/internal/mailer"
+	"github.com/Seician/bookings/internal/metrics"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository/dbrepo"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestOutbox returns an outbox for a migrated sqlite database that sends to memory
+func newTestOutbox(t *testing.T, maxAttempts int) (*Outbox, *mailer.Memory) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.SQL.Close() })
+
+	if err = dbrepo.MigrateSQLite(db.SQL, "./../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.AppConfig{
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Mail:    config.MailConfig{Workers: 2, MaxAttempts: maxAttempts},
+		Metrics: metrics.New(),
+	}
+
+	m := &mailer.Memory{}
+	return New(app, dbrepo.NewSQLiteRepo(db.SQL, app), m), m
+}
+
+// waitForStatus waits for the message id to reach status
+func waitForStatus(t *testing.T, o *Outbox, id int, status string) models.MailMessage {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		msg, err := o.DB.GetMailByID(context.Background(), id)
+		if err == nil && msg.Status == status {
+			return msg
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message %d is %q, wanted %q", id, msg.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutbox_Run(t *testing.T) {
+	o, m := newTestOutbox(t, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+
+	// enqueueing wakes a worker, well before the next poll
+	if err := o.Enqueue(context.Background(), models.MailData{To: "john@smith.com", Subject: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := waitForStatus(t, o, 1, models.MailSent)
+	if msg.Attempts != 1 || len(msg.Deliveries) != 1 {
+		t.Errorf("got %+v, wanted a message sent at the first attempt", msg)
+	}
+	if !o.Running() {
+		t.Error("the workers are not running")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once cancelled")
+	}
+	if o.Running() {
+		t.Error("the workers are still running once Run returned")
+	}
+
+	sent := m.Messages()
+	if len(sent) != 1 || sent[0].To != "john@smith.com" {
+		t.Errorf("sent %+v, wanted one email to john@smith.com", sent)
+	}
+}
+
+func TestOutbox_deliver(t *testing.T) {
+	o, m := newTestOutbox(t, 2)
+	m.SetErr(errors.New("connection refused"))
+	ctx := context.Background()
+
+	var logs bytes.Buffer
+	o.App.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	if err := o.Enqueue(ctx, models.MailData{To: "john@smith.com", Subject: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first failure is retried after the backoff
+	messages, _ := o.DB.ClaimMail(ctx, 1)
+	if len(messages) != 1 {
+		t.Fatal("the message was not claimed")
+	}
+	before := time.Now()
+	o.deliver(messages[0])
+
+	msg, _ := o.DB.GetMailByID(ctx, 1)
+	if msg.Status != models.MailPending || msg.LastError != "connection refused" {
+		t.Errorf("got %+v, wanted a pending message with the error", msg)
+	}
+	if msg.NextAttemptAt.Before(before.Add(Backoff(1) - time.Second)) {
+		t.Errorf("next attempt at %s, wanted it a minute from now", msg.NextAttemptAt)
+	}
+
+	// the last attempt gives up
+	msg.Attempts = 1
+	o.deliver(msg)
+	msg, _ = o.DB.GetMailByID(ctx, 1)
+	if msg.Status != models.MailFailed || msg.Attempts != 2 || len(msg.Deliveries) != 2 {
+		t.Errorf("got %+v, wanted a message failed after 2 attempts", msg)
+	}
+
+	// a resent message gets a fresh set of attempts, numbered on from the earlier ones
+	msg.AttemptsBeforeResend = msg.Attempts
+	o.deliver(msg)
+	msg, _ = o.DB.GetMailByID(ctx, 1)
+	if msg.Status != models.MailPending || msg.Attempts != 3 || msg.Deliveries[2].Attempt != 3 {
+		t.Errorf("got %+v, wanted a resent message pending after its third attempt", msg)
+	}
+
+	// messages are logged by id, never by who they're for
+	if !strings.Contains(logs.String(), "mail_id=1") || strings.Contains(logs.String(), "john@smith.com") {
+		t.Errorf("got logs %q, wanted the mail id without the address", logs.String())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := Backoff(e.attempt); got != e.expected {
+			t.Errorf("attempt %d: got %s, wanted %s", e.attempt, got, e.expected)
+		}
+	}
+}
+
+func TestOutbox_Flush(t *testing.T) {
+	o, m := newTestOutbox(t, 3)
+	ctx := context.Background()
+
+	for _, to := range []string{"john@smith.com", "jane@smith.com"} {
+		if err := o.Enqueue(ctx, models.MailData{To: to, Subject: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sent := m.Messages(); len(sent) != 2 {
+		t.Errorf("sent %d emails, wanted the 2 queued", len(sent))
+	}
+
+	// a failure is retried later rather than straight away
+	m.SetErr(errors.New("connection refused"))
+	_ = o.Enqueue(ctx, models.MailData{To: "owner@example.com", Subject: "Hello"})
+	if err := o.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := o.DB.GetMailByID(ctx, 3)
+	if msg.Status != models.MailPending || msg.Attempts != 1 {
+		t.Errorf("got %+v, wanted a pending message tried once", msg)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := o.Flush(cancelled); err != context.Canceled {
+		t.Errorf("got %v, wanted context.Canceled", err)
+	}
+}
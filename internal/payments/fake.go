@@ -0,0 +1,230 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Payment methods the fake provider understands, standing in for the ids a real provider's script
+// gives a card; any other method is declined
+const (
+	MethodSuccess  = "pm_fake_success"
+	MethodDeclined = "pm_fake_declined"
+	MethodThreeDS  = "pm_fake_3ds"
+)
+
+// FakePath is where the routes mount the fake provider, which serves its 3-D Secure page
+const FakePath = "/payments/fake"
+
+// Fake is a provider that keeps payments in memory, for running and testing the site locally.
+// Payments are approved, declined or sent through a 3-D Secure check depending on their method
+type Fake struct {
+	mu       sync.Mutex
+	secret   []byte
+	next     int
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	Payment
+	returnURL string
+}
+
+// NewFake returns a fake provider that signs webhooks with secret
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Authorize holds req.Amount on the card, or asks for a 3-D Secure check first
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	p := &fakePayment{
+		Payment:   Payment{ID: fmt.Sprintf("fake_%d", f.next), Amount: req.Amount},
+		returnURL: req.ReturnURL,
+	}
+	f.payments[p.ID] = p
+
+	switch req.PaymentMethod {
+	case MethodSuccess:
+		p.Status = StatusAuthorized
+	case MethodThreeDS:
+		p.Status = StatusRequiresAction
+		p.RedirectURL = FakePath + "/3ds/" + p.ID
+	default:
+		p.Status = StatusDeclined
+		return p.Payment, ErrDeclined
+	}
+
+	return p.Payment, nil
+}
+
+// Get returns a payment as it is now
+func (f *Fake) Get(ctx context.Context, paymentID string) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	return p.Payment, nil
+}
+
+// Capture takes the amount held by an authorized payment
+func (f *Fake) Capture(ctx context.Context, paymentID string) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+
+	switch p.Status {
+	case StatusAuthorized:
+		p.Status = StatusCaptured
+	case StatusDeclined:
+		return p.Payment, ErrDeclined
+	default:
+		return p.Payment, ErrInvalidState
+	}
+	return p.Payment, nil
+}
+
+// Void releases the amount held by a payment that hasn't been captured
+func (f *Fake) Void(ctx context.Context, paymentID string) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+
+	switch p.Status {
+	case StatusAuthorized, StatusRequiresAction:
+		p.Status = StatusVoided
+		p.RedirectURL = ""
+	default:
+		return p.Payment, ErrInvalidState
+	}
+	return p.Payment, nil
+}
+
+// Refund gives amount of a captured payment back; an amount of 0 refunds whatever is left
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount int) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	if p.Status != StatusCaptured {
+		return p.Payment, ErrInvalidState
+	}
+
+	left := p.Amount - p.Refunded
+	if amount == 0 {
+		amount = left
+	}
+	if amount < 0 || amount > left {
+		return p.Payment, fmt.Errorf("can't refund %d of the %d left: %w", amount, left, ErrInvalidState)
+	}
+
+	p.Refunded += amount
+	if p.Refunded == p.Amount {
+		p.Status = StatusRefunded
+	}
+	return p.Payment, nil
+}
+
+// Sign returns the signature the fake provider sends with a webhook payload
+func (f *Fake) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the HMAC-SHA256 signature of a webhook and returns its event
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var e Event
+
+	if !hmac.Equal([]byte(f.Sign(payload)), []byte(signature)) {
+		return e, ErrInvalidSignature
+	}
+
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+var challengePage = template.Must(template.New("3ds").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>3-D Secure</title></head>
+<body>
+<h1>3-D Secure check</h1>
+<p>This is the fake payment provider. Approve or fail the check for {{.ID}}.</p>
+<p><a href="?result=approve">Approve</a> <a href="?result=fail">Fail</a></p>
+</body>
+</html>
+`))
+
+// ServeHTTP serves the 3-D Secure page at /3ds/{id}, under FakePath. Approving or failing the check
+// sends the guest back to the return url of the payment
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/3ds/")
+
+	f.mu.Lock()
+	p, ok := f.payments[id]
+	f.mu.Unlock()
+	if !ok || id == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	result := r.URL.Query().Get("result")
+	if result == "" {
+		_ = challengePage.Execute(w, p)
+		return
+	}
+
+	f.mu.Lock()
+	if p.Status == StatusRequiresAction {
+		p.Status = StatusDeclined
+		if result == "approve" {
+			p.Status = StatusAuthorized
+		}
+		p.RedirectURL = ""
+	}
+	returnURL := p.returnURL
+	f.mu.Unlock()
+
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	q.Set("payment_id", id)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
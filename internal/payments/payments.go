@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// Payment statuses
+const (
+	StatusRequiresAction = "requires_action"
+	StatusAuthorized     = "authorized"
+	StatusCaptured       = "captured"
+	StatusDeclined       = "declined"
+	StatusRefunded       = "refunded"
+	StatusVoided         = "voided"
+)
+
+// SignatureHeader is the request header webhooks carry their signature in
+const SignatureHeader = "X-Payment-Signature"
+
+// Webhook event types
+const (
+	EventCaptured = "payment.captured"
+	EventDeclined = "payment.declined"
+	EventRefunded = "payment.refunded"
+)
+
+var (
+	// ErrDeclined is returned when the card issuer refuses a payment
+	ErrDeclined = errors.New("the card was declined")
+	// ErrNotFound is returned for a payment id the provider doesn't know
+	ErrNotFound = errors.New("payment not found")
+	// ErrInvalidState is returned when capturing or refunding a payment that isn't ready for it
+	ErrInvalidState = errors.New("payment can't do that in its current state")
+	// ErrInvalidSignature is returned for a webhook that wasn't sent by the provider
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// AuthorizeRequest asks for an amount, in cents, to be held on a guest's card. PaymentMethod is
+// the id the provider's script in the guest's browser gave the card, so the card number itself
+// never reaches the site. ReturnURL is where the guest comes back to after a 3-D Secure check,
+// with the payment id in the payment_id parameter
+type AuthorizeRequest struct {
+	Amount        int
+	Currency      string
+	Description   string
+	Email         string
+	PaymentMethod string
+	ReturnURL     string
+}
+
+// Payment is a payment as the provider sees it. RedirectURL is set while the status is
+// StatusRequiresAction, and is where the guest completes the 3-D Secure check
+type Payment struct {
+	ID          string
+	Amount      int
+	Refunded    int
+	Status      string
+	RedirectURL string
+}
+
+// Event is a notification from the provider that a payment changed
+type Event struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	PaymentID string `json:"payment_id"`
+	Amount    int    `json:"amount"`
+}
+
+// Provider takes payments from guests. Authorize holds an amount on a card, Get returns a payment
+// as it is now, Capture takes the held amount, Void releases it without taking it, Refund gives
+// some or all of a captured payment back, and VerifyWebhook checks that a notification really
+// comes from the provider before its event is used
+type Provider interface {
+	Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error)
+	Get(ctx context.Context, paymentID string) (Payment, error)
+	Capture(ctx context.Context, paymentID string) (Payment, error)
+	Void(ctx context.Context, paymentID string) (Payment, error)
+	Refund(ctx context.Context, paymentID string, amount int) (Payment, error)
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// Deposit returns the part of total, in cents, taken when booking; percent is clamped to 0 to 100
+func Deposit(total, percent int) int {
+	if percent <= 0 || total <= 0 {
+		return 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return total * percent / 100
+}
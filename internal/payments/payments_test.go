@@ -0,0 +1,209 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeposit(t *testing.T) {
+	var tests = []struct {
+		total    int
+		percent  int
+		expected int
+	}{
+		{15000, 20, 3000},
+		{15000, 0, 0},
+		{15000, 150, 15000},
+		{0, 20, 0},
+		{999, 33, 329},
+	}
+
+	for _, e := range tests {
+		if got := Deposit(e.total, e.percent); got != e.expected {
+			t.Errorf("deposit of %d%% of %d: got %d, wanted %d", e.percent, e.total, got, e.expected)
+		}
+	}
+}
+
+func TestFake_AuthorizeCaptureRefund(t *testing.T) {
+	f := NewFake("secret")
+	ctx := context.Background()
+
+	p, err := f.Authorize(ctx, AuthorizeRequest{Amount: 3000, PaymentMethod: MethodSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != StatusAuthorized {
+		t.Fatalf("got status %q, wanted %q", p.Status, StatusAuthorized)
+	}
+
+	_, err = f.Refund(ctx, p.ID, 0)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("refunding before capture: got %v, wanted ErrInvalidState", err)
+	}
+
+	p, err = f.Capture(ctx, p.ID)
+	if err != nil || p.Status != StatusCaptured {
+		t.Fatalf("capture: got status %q and error %v", p.Status, err)
+	}
+
+	_, err = f.Capture(ctx, p.ID)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("capturing twice: got %v, wanted ErrInvalidState", err)
+	}
+
+	p, err = f.Refund(ctx, p.ID, 1000)
+	if err != nil || p.Refunded != 1000 || p.Status != StatusCaptured {
+		t.Errorf("partial refund: got %d refunded, status %q and error %v", p.Refunded, p.Status, err)
+	}
+	_, err = f.Refund(ctx, p.ID, 5000)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("refunding too much: got %v, wanted ErrInvalidState", err)
+	}
+	p, err = f.Refund(ctx, p.ID, 0)
+	if err != nil || p.Refunded != 3000 || p.Status != StatusRefunded {
+		t.Errorf("full refund: got %d refunded, status %q and error %v", p.Refunded, p.Status, err)
+	}
+
+	_, err = f.Capture(ctx, "fake_99")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown payment: got %v, wanted ErrNotFound", err)
+	}
+}
+
+func TestFake_Void(t *testing.T) {
+	f := NewFake("secret")
+	ctx := context.Background()
+
+	p, err := f.Authorize(ctx, AuthorizeRequest{Amount: 3000, PaymentMethod: MethodSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = f.Void(ctx, p.ID)
+	if err != nil || p.Status != StatusVoided {
+		t.Fatalf("void: got status %q and error %v", p.Status, err)
+	}
+	_, err = f.Capture(ctx, p.ID)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("capturing a voided payment: got %v, wanted ErrInvalidState", err)
+	}
+
+	p, _ = f.Authorize(ctx, AuthorizeRequest{Amount: 3000, PaymentMethod: MethodSuccess})
+	_, _ = f.Capture(ctx, p.ID)
+	_, err = f.Void(ctx, p.ID)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("voiding a captured payment: got %v, wanted ErrInvalidState", err)
+	}
+
+	_, err = f.Void(ctx, "fake_99")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown payment: got %v, wanted ErrNotFound", err)
+	}
+}
+
+func TestFake_Declined(t *testing.T) {
+	f := NewFake("secret")
+
+	for _, method := range []string{MethodDeclined, "pm_unknown"} {
+		p, err := f.Authorize(context.Background(), AuthorizeRequest{Amount: 3000, PaymentMethod: method})
+		if !errors.Is(err, ErrDeclined) {
+			t.Errorf("method %s: got %v, wanted ErrDeclined", method, err)
+		}
+		if p.Status != StatusDeclined {
+			t.Errorf("method %s: got status %q, wanted %q", method, p.Status, StatusDeclined)
+		}
+	}
+}
+
+func TestFake_ThreeDS(t *testing.T) {
+	var tests = []struct {
+		name     string
+		result   string
+		expected string
+	}{
+		{"approved", "approve", StatusAuthorized},
+		{"failed", "fail", StatusDeclined},
+	}
+
+	for _, e := range tests {
+		f := NewFake("secret")
+		p, err := f.Authorize(context.Background(), AuthorizeRequest{
+			Amount:        3000,
+			PaymentMethod: MethodThreeDS,
+			ReturnURL:     "http://localhost/make-reservation/payment",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != StatusRequiresAction || !strings.HasPrefix(p.RedirectURL, FakePath+"/3ds/") {
+			t.Fatalf("%s: got status %q redirecting to %q", e.name, p.Status, p.RedirectURL)
+		}
+
+		// the challenge page
+		req := httptest.NewRequest("GET", strings.TrimPrefix(p.RedirectURL, FakePath), nil)
+		rr := httptest.NewRecorder()
+		f.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Approve") {
+			t.Errorf("%s: challenge page returned %d", e.name, rr.Code)
+		}
+
+		req = httptest.NewRequest("GET", strings.TrimPrefix(p.RedirectURL, FakePath)+"?result="+e.result, nil)
+		rr = httptest.NewRecorder()
+		f.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: got status code %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if loc := rr.Header().Get("Location"); loc != "http://localhost/make-reservation/payment?payment_id="+p.ID {
+			t.Errorf("%s: redirected to %q", e.name, loc)
+		}
+
+		got, err := f.Get(context.Background(), p.ID)
+		if err != nil || got.Status != e.expected {
+			t.Errorf("%s: got status %q and error %v, wanted %q", e.name, got.Status, err, e.expected)
+		}
+
+		_, err = f.Capture(context.Background(), p.ID)
+		if e.expected == StatusAuthorized && err != nil {
+			t.Errorf("%s: capture failed: %v", e.name, err)
+		}
+		if e.expected == StatusDeclined && !errors.Is(err, ErrDeclined) {
+			t.Errorf("%s: got %v, wanted ErrDeclined", e.name, err)
+		}
+	}
+
+	f := NewFake("secret")
+	if _, err := f.Get(context.Background(), "fake_1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown payment: got %v, wanted ErrNotFound", err)
+	}
+	rr := httptest.NewRecorder()
+	f.ServeHTTP(rr, httptest.NewRequest("GET", "/3ds/fake_1", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown payment: got status code %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	f := NewFake("secret")
+	payload := []byte(`{"id":"evt_1","type":"payment.refunded","payment_id":"fake_1","amount":3000}`)
+
+	e, err := f.VerifyWebhook(payload, f.Sign(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventRefunded || e.PaymentID != "fake_1" || e.Amount != 3000 {
+		t.Errorf("got event %+v", e)
+	}
+
+	_, err = f.VerifyWebhook(payload, NewFake("other").Sign(payload))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong secret: got %v, wanted ErrInvalidSignature", err)
+	}
+	_, err = f.VerifyWebhook(payload, "")
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("no signature: got %v, wanted ErrInvalidSignature", err)
+	}
+}
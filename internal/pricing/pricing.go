@@ -0,0 +1,77 @@
+package pricing
+
+import (
+	"errors"
+	"github.com/Seician/bookings/internal/models"
+	"time"
+)
+
+// ErrNoNights is returned for a stay that doesn't end after it starts
+var ErrNoNights = errors.New("departure must be after arrival")
+
+// Night is the price of one night of a stay
+type Night struct {
+	Date    time.Time
+	Rate    int
+	Weekend bool
+	Season  string
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	Nights []Night
+	Total  int
+}
+
+// Calculate prices a stay in room from start up to the departure day end. Rates are in cents.
+// Friday and Saturday nights are weekend nights. A season covering a night wins over the room's
+// own rates, and where seasons overlap the one listed last wins; a zero weekend rate falls back
+// to the nightly rate
+func Calculate(room models.Room, seasons []models.RoomRate, start, end time.Time) (Quote, error) {
+	var q Quote
+
+	start = dateOnly(start)
+	end = dateOnly(end)
+	if !end.After(start) {
+		return q, ErrNoNights
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := Night{
+			Date:    d,
+			Weekend: d.Weekday() == time.Friday || d.Weekday() == time.Saturday,
+		}
+
+		nightly, weekend := room.BaseRate, room.WeekendRate
+		for _, s := range seasons {
+			if !d.Before(dateOnly(s.StartDate)) && !d.After(dateOnly(s.EndDate)) {
+				nightly, weekend = s.NightlyRate, s.WeekendRate
+				night.Season = s.Name
+			}
+		}
+
+		night.Rate = nightly
+		if night.Weekend && weekend > 0 {
+			night.Rate = weekend
+		}
+
+		q.Nights = append(q.Nights, night)
+		q.Total += night.Rate
+	}
+
+	return q, nil
+}
+
+// ReservationNights returns the nights of q the way a reservation stores them
+func (q Quote) ReservationNights() []models.ReservationNight {
+	nights := make([]models.ReservationNight, len(q.Nights))
+	for i, n := range q.Nights {
+		nights[i] = models.ReservationNight{Date: n.Date, Rate: n.Rate, Weekend: n.Weekend, Season: n.Season}
+	}
+	return nights
+}
+
+// dateOnly drops the time of day and location, as dates come back from the drivers in either
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,148 @@
+package pricing
+
+import (
+	"errors"
+	"github.com/Seician/bookings/internal/models"
+	"testing"
+	"time"
+)
+
+var room = models.Room{ID: 1, BaseRate: 10000, WeekendRate: 12000}
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestCalculate(t *testing.T) {
+	// Wednesday 2050-01-05 to Monday 2050-01-10: Wed, Thu, Fri, Sat, Sun nights
+	q, err := Calculate(room, nil, date("2050-01-05"), date("2050-01-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.Nights) != 5 {
+		t.Fatalf("got %d nights, wanted 5", len(q.Nights))
+	}
+	if !q.Nights[2].Weekend || !q.Nights[3].Weekend || q.Nights[4].Weekend {
+		t.Error("only Friday and Saturday should be weekend nights")
+	}
+	if q.Total != 3*10000+2*12000 {
+		t.Errorf("got total %d, wanted %d", q.Total, 3*10000+2*12000)
+	}
+}
+
+func TestCalculate_Seasons(t *testing.T) {
+	seasons := []models.RoomRate{
+		{Name: "Winter", StartDate: date("2050-01-01"), EndDate: date("2050-01-31"), NightlyRate: 8000},
+		{Name: "Holiday", StartDate: date("2050-01-07"), EndDate: date("2050-01-07"), NightlyRate: 20000, WeekendRate: 25000},
+	}
+
+	q, err := Calculate(room, seasons, date("2050-01-05"), date("2050-01-09"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = []struct {
+		rate   int
+		season string
+	}{
+		{8000, "Winter"},
+		{8000, "Winter"},
+		{25000, "Holiday"},
+		// no weekend rate in the season, so not the room's either
+		{8000, "Winter"},
+	}
+	for i, e := range expected {
+		if q.Nights[i].Rate != e.rate || q.Nights[i].Season != e.season {
+			t.Errorf("night %d: got %d (%s), wanted %d (%s)", i, q.Nights[i].Rate, q.Nights[i].Season, e.rate, e.season)
+		}
+	}
+	if q.Total != 8000*3+25000 {
+		t.Errorf("got total %d, wanted %d", q.Total, 8000*3+25000)
+	}
+}
+
+func TestCalculate_NoNights(t *testing.T) {
+	_, err := Calculate(room, nil, date("2050-01-05"), date("2050-01-05"))
+	if !errors.Is(err, ErrNoNights) {
+		t.Errorf("got %v for a stay without nights, wanted ErrNoNights", err)
+	}
+}
+
+func TestCalculate_NoWeekendRate(t *testing.T) {
+	q, _ := Calculate(models.Room{BaseRate: 5000}, nil, date("2050-01-07"), date("2050-01-09"))
+	if q.Total != 10000 {
+		t.Errorf("got total %d, wanted the base rate for both weekend nights", q.Total)
+	}
+}
+
+func TestQuote_ReservationNights(t *testing.T) {
+	seasons := []models.RoomRate{{Name: "Winter", StartDate: date("2050-01-07"), EndDate: date("2050-01-31"), NightlyRate: 8000}}
+	q, err := Calculate(room, seasons, date("2050-01-06"), date("2050-01-08"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nights := q.ReservationNights()
+	if len(nights) != 2 {
+		t.Fatalf("got %d nights, wanted 2", len(nights))
+	}
+	for i, n := range q.Nights {
+		if nights[i] != (models.ReservationNight{Date: n.Date, Rate: n.Rate, Weekend: n.Weekend, Season: n.Season}) {
+			t.Errorf("night %d: got %+v, wanted %+v", i, nights[i], n)
+		}
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	// Wednesday to Saturday: 3 nights, 2 of them at the nightly rate and Friday at the weekend rate
+	q, err := Calculate(room, nil, date("2050-01-05"), date("2050-01-08"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summer := models.PromoCode{
+		Code:         "SUMMER10",
+		DiscountType: models.DiscountPercent,
+		Amount:       10,
+		StartDate:    date("2050-01-01"),
+		EndDate:      date("2050-01-31"),
+		MinNights:    1,
+		Active:       true,
+	}
+
+	var tests = []struct {
+		name     string
+		change   func(c *models.PromoCode)
+		roomId   int
+		expected int
+		err      error
+	}{
+		{"percent", func(c *models.PromoCode) {}, 1, 3200, nil},
+		{"fixed", func(c *models.PromoCode) { c.DiscountType, c.Amount = models.DiscountFixed, 5000 }, 1, 5000, nil},
+		{"fixed above the total", func(c *models.PromoCode) { c.DiscountType, c.Amount = models.DiscountFixed, 50000 }, 1, 32000, nil},
+		{"inactive", func(c *models.PromoCode) { c.Active = false }, 1, 0, ErrPromoInactive},
+		{"not started", func(c *models.PromoCode) { c.StartDate = date("2050-01-06") }, 1, 0, ErrPromoDates},
+		{"arrives on the last day", func(c *models.PromoCode) { c.EndDate = date("2050-01-05") }, 1, 3200, nil},
+		{"expired", func(c *models.PromoCode) { c.EndDate = date("2050-01-04") }, 1, 0, ErrPromoDates},
+		{"eligible room", func(c *models.PromoCode) { c.RoomIds = []int{2, 1} }, 1, 3200, nil},
+		{"other room", func(c *models.PromoCode) { c.RoomIds = []int{2} }, 1, 0, ErrPromoRoom},
+		{"used up", func(c *models.PromoCode) { c.MaxRedemptions, c.Redemptions = 5, 5 }, 1, 0, ErrPromoUsedUp},
+		{"redemptions left", func(c *models.PromoCode) { c.MaxRedemptions, c.Redemptions = 5, 4 }, 1, 3200, nil},
+		{"too short", func(c *models.PromoCode) { c.MinNights = 4 }, 1, 0, ErrPromoMinNights},
+	}
+
+	for _, e := range tests {
+		code := summer
+		e.change(&code)
+
+		discount, err := Discount(code, e.roomId, q)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: got error %v, wanted %v", e.name, err, e.err)
+		}
+		if discount != e.expected {
+			t.Errorf("%s: got discount %d, wanted %d", e.name, discount, e.expected)
+		}
+	}
+}
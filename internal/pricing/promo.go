@@ -0,0 +1,68 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Seician/bookings/internal/models"
+)
+
+// Errors returned by Discount, worded to be shown to the guest
+var (
+	ErrPromoInactive  = errors.New("this code is no longer valid")
+	ErrPromoDates     = errors.New("this code is not valid for stays arriving on this date")
+	ErrPromoRoom      = errors.New("this code is not valid for this room")
+	ErrPromoUsedUp    = errors.New("this code has been used up")
+	ErrPromoMinNights = errors.New("this code needs a longer stay")
+)
+
+// Discount checks that code may be used for a stay in room with quote q and returns the discount
+// in cents. The stay must arrive within the code's validity window; a discount never exceeds the total
+func Discount(code models.PromoCode, roomId int, q Quote) (int, error) {
+	if !code.Active {
+		return 0, ErrPromoInactive
+	}
+	if len(q.Nights) == 0 {
+		return 0, ErrNoNights
+	}
+
+	arrival := q.Nights[0].Date
+	if arrival.Before(dateOnly(code.StartDate)) || arrival.After(dateOnly(code.EndDate)) {
+		return 0, ErrPromoDates
+	}
+
+	if len(code.RoomIds) > 0 {
+		eligible := false
+		for _, id := range code.RoomIds {
+			if id == roomId {
+				eligible = true
+				break
+			}
+		}
+		if !eligible {
+			return 0, ErrPromoRoom
+		}
+	}
+
+	if code.MaxRedemptions > 0 && code.Redemptions >= code.MaxRedemptions {
+		return 0, ErrPromoUsedUp
+	}
+
+	if len(q.Nights) < code.MinNights {
+		return 0, fmt.Errorf("%w of at least %d nights", ErrPromoMinNights, code.MinNights)
+	}
+
+	var discount int
+	switch code.DiscountType {
+	case models.DiscountPercent:
+		discount = q.Total * code.Amount / 100
+	case models.DiscountFixed:
+		discount = code.Amount
+	default:
+		return 0, fmt.Errorf("unknown discount type %q", code.DiscountType)
+	}
+
+	if discount > q.Total {
+		discount = q.Total
+	}
+	return discount, nil
+}
@@ -0,0 +1,75 @@
+package render
+
+import (
+	"github.com/Seician/bookings/internal/models"
+	"time"
+)
+
+// CalendarCell is one room on one day of the reservations calendar
+type CalendarCell struct {
+	Date        time.Time
+	Restriction models.RoomRestriction
+	Booked      bool
+	First       bool
+}
+
+// CalendarRow is a room and its cells, one per day of the month
+type CalendarRow struct {
+	Room  models.Room
+	Cells []CalendarCell
+}
+
+// MonthStart returns midnight on the first day of the month t falls in
+func MonthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MonthDays returns every day of the month t falls in
+func MonthDays(t time.Time) []time.Time {
+	first := MonthStart(t)
+
+	var days []time.Time
+	for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// CalendarRowFor lays the restrictions of a room out over days. A restriction covers the days from
+// its start date up to, but not including, its end date, so a departure day stays free for the next guest
+func CalendarRowFor(room models.Room, days []time.Time, restrictions []models.RoomRestriction) CalendarRow {
+	row := CalendarRow{Room: room}
+
+	for _, d := range days {
+		cell := CalendarCell{Date: d}
+		for _, rr := range restrictions {
+			start := dateOnly(rr.StartDate)
+			end := dateOnly(rr.EndDate)
+			if d.Before(start) || (!d.Before(end) && !d.Equal(start)) {
+				continue
+			}
+			cell.Restriction = rr
+			cell.Booked = true
+			cell.First = d.Equal(start) || d.Equal(days[0])
+			break
+		}
+		row.Cells = append(row.Cells, cell)
+	}
+
+	return row
+}
+
+// Weekday returns the short name of the day, like Mon
+func Weekday(t time.Time) string {
+	return t.Format("Mon")
+}
+
+// IsWeekend reports whether t is a Saturday or Sunday
+func IsWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// dateOnly drops the time of day and location, as dates come back from the drivers in either
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
+	"html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Mail renders the email template name with data, returning its HTML body and plain text alternative
+func Mail(name string, data interface{}) (string, string, error) {
+	mc := app.MailTemplates
+	if !app.UseCache {
+		var err error
+		mc, err = CreateMailTemplateCache()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	ht, ok := mc.HTML[name]
+	if !ok {
+		return "", "", fmt.Errorf("can't get email template %q from cache", name)
+	}
+	tt, ok := mc.Text[name]
+	if !ok {
+		return "", "", fmt.Errorf("can't get the text version of email template %q from cache", name)
+	}
+
+	var html, text bytes.Buffer
+	err := ht.Execute(&html, data)
+	if err != nil {
+		return "", "", err
+	}
+	err = tt.Execute(&text, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return html.String(), text.String(), nil
+}
+
+// CreateMailTemplateCache parses the email templates in the email folder of the templates. Every
+// email has a name.html.tmpl version, laid out by the *.layout.tmpl files there, and a name.txt.tmpl
+// plain text version
+func CreateMailTemplateCache() (config.MailTemplateCache, error) {
+	mc := config.MailTemplateCache{
+		HTML: map[string]*template.Template{},
+		Text: map[string]*texttemplate.Template{},
+	}
+	dir := filepath.Join(pathToTemplates, "email")
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.html.tmpl"))
+	if err != nil {
+		return mc, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html.tmpl")
+
+		ht, err := template.New(filepath.Base(page)).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return mc, err
+		}
+		layouts, err := filepath.Glob(filepath.Join(dir, "*.layout.tmpl"))
+		if err != nil {
+			return mc, err
+		}
+		if len(layouts) > 0 {
+			ht, err = ht.ParseFiles(layouts...)
+			if err != nil {
+				return mc, err
+			}
+		}
+
+		textPage := filepath.Join(dir, name+".txt.tmpl")
+		tt, err := texttemplate.New(filepath.Base(textPage)).Funcs(texttemplate.FuncMap(functions)).ParseFiles(textPage)
+		if err != nil {
+			return mc, err
+		}
+
+		mc.HTML[name] = ht
+		mc.Text[name] = tt
+	}
+
+	return mc, nil
+}
@@ -10,9 +10,19 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"time"
 )
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":  HumanDate,
+	"formatDate": FormatDate,
+	"iterate":    Iterate,
+	"add":        Add,
+	"addDays":    AddDays,
+	"weekday":    Weekday,
+	"isWeekend":  IsWeekend,
+	"money":      Money,
+}
 
 var app *config.AppConfig
 var pathToTemplates = "./templates"
@@ -22,6 +32,51 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// HumanDate returns time in YYYY-MM-DD format
+func HumanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// FormatDate returns time in the given layout
+func FormatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// Iterate returns a slice of ints, starting at 1, going to count
+func Iterate(count int) []int {
+	var items []int
+	for i := 1; i <= count; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+// Add returns the sum of two ints
+func Add(a, b int) int {
+	return a + b
+}
+
+// AddDays returns t moved by n days
+func AddDays(t time.Time, n int) time.Time {
+	return t.AddDate(0, 0, n)
+}
+
+// Money formats an amount in cents as dollars, like $1,234.50
+func Money(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := fmt.Sprintf("%d", cents/100)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -58,7 +113,7 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 
 	_, err := buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		app.Logger.ErrorContext(r.Context(), "can't write template to browser", "template", tmpl, "error", err)
 		return err
 	}
 	return nil
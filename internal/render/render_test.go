@@ -1,9 +1,13 @@
 package render
 
 import (
+	"fmt"
+	"github.com/Seician/bookings/internal/config"
 	"github.com/Seician/bookings/internal/models"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddDefaultData(t *testing.T) {
@@ -47,6 +51,50 @@ func TestRenderTemplate(t *testing.T) {
 	}
 }
 
+func TestMail(t *testing.T) {
+	pathToTemplates = "./../../templates"
+	mc, err := CreateMailTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mc.Text["confirmation"]; !ok {
+		t.Fatal("confirmation email has no text version")
+	}
+
+	app.MailTemplates = mc
+	app.UseCache = true
+	defer func() { app.UseCache = false }()
+
+	data := struct {
+		Property       config.PropertyConfig
+		Reservation    models.Reservation
+		Nights         int
+		ManageURL      string
+		InvoiceMissing bool
+	}{
+		Property:    config.PropertyConfig{Name: "Fort Smythe"},
+		Reservation: models.Reservation{FirstName: "John", TotalPrice: 12345},
+		Nights:      1,
+		ManageURL:   "http://localhost/manage",
+	}
+
+	html, text, err := Mail("confirmation", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "<title>Reservation confirmation</title>") || !strings.Contains(html, "$123.45") {
+		t.Error("HTML version is missing its title or total")
+	}
+	if strings.Contains(text, "<") || !strings.Contains(text, "$123.45") {
+		t.Error("text version contains markup or is missing its total")
+	}
+
+	_, _, err = Mail("non-existent", data)
+	if err == nil {
+		t.Error("rendered email template that does not exist")
+	}
+}
+
 func getSession() (*http.Request, error) {
 	request, err := http.NewRequest("GET", "/some-url", nil)
 	if err != nil {
@@ -70,3 +118,61 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMonthDays(t *testing.T) {
+	days := MonthDays(time.Date(2024, time.February, 17, 15, 0, 0, 0, time.Local))
+	if len(days) != 29 {
+		t.Errorf("got %d days in February 2024, wanted 29", len(days))
+	}
+	if days[0].Day() != 1 || days[len(days)-1].Day() != 29 {
+		t.Errorf("got days %s to %s", days[0], days[len(days)-1])
+	}
+}
+
+func TestCalendarRowFor(t *testing.T) {
+	days := MonthDays(time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC))
+	restrictions := []models.RoomRestriction{
+		// arrives the 30th of December, leaves the 3rd
+		{StartDate: days[0].AddDate(0, 0, -2), EndDate: days[2], ReservationId: 1},
+		// three nights from the 10th
+		{StartDate: days[9], EndDate: days[12], ReservationId: 2},
+	}
+
+	row := CalendarRowFor(models.Room{ID: 1}, days, restrictions)
+	if len(row.Cells) != 31 {
+		t.Fatalf("got %d cells, wanted 31", len(row.Cells))
+	}
+
+	var booked []int
+	for _, c := range row.Cells {
+		if c.Booked {
+			booked = append(booked, c.Date.Day())
+		}
+	}
+	if fmt.Sprint(booked) != "[1 2 10 11 12]" {
+		t.Errorf("got booked days %v, wanted [1 2 10 11 12]", booked)
+	}
+	if !row.Cells[0].First || row.Cells[1].First || !row.Cells[9].First {
+		t.Error("first days of the stays are not marked")
+	}
+	if row.Cells[9].Restriction.ReservationId != 2 {
+		t.Errorf("got reservation %d on the 10th, wanted 2", row.Cells[9].Restriction.ReservationId)
+	}
+}
+
+func TestMoney(t *testing.T) {
+	var tests = map[int]string{
+		0:         "$0.00",
+		5:         "$0.05",
+		9900:      "$99.00",
+		123450:    "$1,234.50",
+		100000000: "$1,000,000.00",
+		-2550:     "-$25.50",
+	}
+
+	for cents, expected := range tests {
+		if got := Money(cents); got != expected {
+			t.Errorf("Money(%d) = %s, wanted %s", cents, got, expected)
+		}
+	}
+}
@@ -3,9 +3,10 @@ package render
 import (
 	"encoding/gob"
 	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/logging"
 	"github.com/Seician/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
@@ -23,11 +24,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logging.New(os.Stdout, testApp.InProduction, slog.LevelInfo)
 
 	// set up the session
 	session = scs.New()
@@ -1,14 +1,32 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/models"
 	"github.com/Seician/bookings/internal/repository"
+	"strings"
+	"time"
 )
 
-type mySqlDBRepo struct {
+// defaultQueryTimeout bounds queries when the config doesn't set a deadline
+const defaultQueryTimeout = 3 * time.Second
+
+// sqlDBRepo serves MySQL, Postgres and SQLite. The queries are written with ? placeholders, which
+// rebind turns into those of the dialect
+type sqlDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+	// bind returns the nth placeholder of the dialect
+	bind func(n int) string
+	// lock is appended to the selects of rows a transaction is about to change, to lock them where
+	// the dialect can. SQLite has no row locks, but its pool is a single connection, so its
+	// transactions never run concurrently
+	lock string
+	// returning is set for dialects that report the id of a new row through a returning clause
+	// rather than LastInsertId
+	returning bool
 }
 type testDBRepo struct {
 	App *config.AppConfig
@@ -16,9 +34,29 @@ type testDBRepo struct {
 }
 
 func NewMySqlRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
-	return &mySqlDBRepo{
-		App: a,
-		DB:  conn,
+	return &sqlDBRepo{
+		App:  a,
+		DB:   conn,
+		bind: questionMark,
+		lock: " for update",
+	}
+}
+
+func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqlDBRepo{
+		App:       a,
+		DB:        conn,
+		bind:      dollarN,
+		lock:      " for update",
+		returning: true,
+	}
+}
+
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqlDBRepo{
+		App:  a,
+		DB:   conn,
+		bind: questionMark,
 	}
 }
 
@@ -27,3 +65,63 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// queryContext derives the context for a query from the caller's context,
+// adding the configured default deadline
+func queryContext(ctx context.Context, a *config.AppConfig) (context.Context, context.CancelFunc) {
+	timeout := defaultQueryTimeout
+	if a != nil && a.DB.QueryTimeout > 0 {
+		timeout = a.DB.QueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// rebind replaces the ? placeholders of query with those of the dialect
+func (m *sqlDBRepo) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(m.bind(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// execer is what inserts run on, either the pool or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertID runs the insert statement on db and returns the id of the new row
+func (m *sqlDBRepo) insertID(ctx context.Context, db execer, statement string, args ...interface{}) (int, error) {
+	var id int
+	if m.returning {
+		err := db.QueryRowContext(ctx, m.rebind(statement)+" returning id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, m.rebind(statement), args...)
+	if err != nil {
+		return 0, err
+	}
+	lastId, err := result.LastInsertId()
+	return int(lastId), err
+}
+
+// reservationStatus is the status a new reservation is stored with
+func reservationStatus(res models.Reservation) string {
+	if res.Status == "" {
+		return models.ReservationConfirmed
+	}
+	return res.Status
+}
+
+// nullableID stores a zero id as NULL, for optional foreign keys like room_restrictions.reservation_id
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
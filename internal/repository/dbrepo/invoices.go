@@ -0,0 +1,134 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Seician/bookings/internal/models"
+	"time"
+)
+
+// invoiceForReservation returns the invoice of draft.ReservationId, issuing draft under the number
+// after the last invoice when it has none yet. An invoice issued before invoices kept their lines
+// is given those of draft the first time it's read again. Should two invoices be issued at once,
+// the unique index on number fails the second rather than giving both the same number
+func invoiceForReservation(ctx context.Context, db *sql.DB, bind func(n int) string, draft models.Invoice) (models.Invoice, error) {
+	var inv models.Invoice
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	query := `select id, reservation_id, number, total, tax_percent, tax, paid, created_at
+		from invoices where reservation_id = ` + bind(1)
+	scan := func() error {
+		return tx.QueryRowContext(ctx, query, draft.ReservationId).Scan(&inv.ID, &inv.ReservationId, &inv.Number,
+			&inv.Total, &inv.TaxPercent, &inv.Tax, &inv.Paid, &inv.CreatedAt)
+	}
+
+	err = scan()
+	if err == nil {
+		inv.Lines, err = invoiceLines(ctx, tx, bind, inv.ID)
+		if err != nil || len(inv.Lines) > 0 {
+			return inv, err
+		}
+		err = saveInvoiceLines(ctx, tx, bind, inv.ID, draft)
+		if err != nil {
+			return inv, err
+		}
+		inv.Lines, inv.Total, inv.TaxPercent, inv.Tax, inv.Paid = draft.Lines, draft.Total, draft.TaxPercent, draft.Tax, draft.Paid
+		return inv, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return inv, err
+	}
+
+	// the reservation has to exist, or it would get a number of its own
+	var exists int
+	err = tx.QueryRowContext(ctx, `select count(id) from reservations where id = `+bind(1), draft.ReservationId).Scan(&exists)
+	if err != nil {
+		return inv, err
+	}
+	if exists == 0 {
+		return inv, sql.ErrNoRows
+	}
+
+	var number int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(number), 0) + 1 from invoices`).Scan(&number)
+	if err != nil {
+		return inv, err
+	}
+
+	statement := `insert into invoices (reservation_id, number, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `)`
+
+	_, err = tx.ExecContext(ctx, statement, draft.ReservationId, number, time.Now(), time.Now())
+	if err != nil {
+		return inv, err
+	}
+
+	// read it back for its id, which postgres won't report through LastInsertId
+	err = scan()
+	if err != nil {
+		return inv, err
+	}
+
+	err = saveInvoiceLines(ctx, tx, bind, inv.ID, draft)
+	if err != nil {
+		return inv, err
+	}
+	inv.Lines, inv.Total, inv.TaxPercent, inv.Tax, inv.Paid = draft.Lines, draft.Total, draft.TaxPercent, draft.Tax, draft.Paid
+
+	return inv, tx.Commit()
+}
+
+// invoiceLines returns the lines of an invoice in the order they are printed
+func invoiceLines(ctx context.Context, tx *sql.Tx, bind func(n int) string, invoiceId int) ([]models.InvoiceLine, error) {
+	var lines []models.InvoiceLine
+
+	rows, err := tx.QueryContext(ctx, `select label, detail, amount from invoice_lines
+		where invoice_id = `+bind(1)+` order by position`, invoiceId)
+	if err != nil {
+		return lines, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.InvoiceLine
+		err := rows.Scan(&l.Label, &l.Detail, &l.Amount)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return lines, err
+	}
+
+	return lines, nil
+}
+
+// saveInvoiceLines stores the lines and totals of inv against the invoice invoiceId
+func saveInvoiceLines(ctx context.Context, tx *sql.Tx, bind func(n int) string, invoiceId int, inv models.Invoice) error {
+	statement := `update invoices set total = ` + bind(1) + `, tax_percent = ` + bind(2) + `, tax = ` + bind(3) + `,
+		paid = ` + bind(4) + `, updated_at = ` + bind(5) + ` where id = ` + bind(6)
+
+	_, err := tx.ExecContext(ctx, statement, inv.Total, inv.TaxPercent, inv.Tax, inv.Paid, time.Now(), invoiceId)
+	if err != nil {
+		return err
+	}
+
+	statement = `insert into invoice_lines (invoice_id, position, label, detail, amount, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `, ` + bind(7) + `)`
+
+	for i, l := range inv.Lines {
+		_, err = tx.ExecContext(ctx, statement, invoiceId, i+1, l.Label, l.Detail, l.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
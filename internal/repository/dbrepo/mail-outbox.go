@@ -0,0 +1,307 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"time"
+)
+
+// mailClaimTimeout is how long a message can be sending before it is taken to have been abandoned,
+// by a worker that stopped halfway, and is handed out again
+const mailClaimTimeout = 10 * time.Minute
+
+// mailColumns are the columns scanMail expects, in order
+const mailColumns = `id, to_address, from_address, subject, content, plain_content, status, attempts,
+	attempts_before_resend, next_attempt_at, last_error, sent_at, created_at, updated_at`
+
+// scanMail reads outbox messages selected with mailColumns and closes rows
+func scanMail(rows *sql.Rows) ([]models.MailMessage, error) {
+	defer rows.Close()
+
+	var messages []models.MailMessage
+
+	for rows.Next() {
+		var m models.MailMessage
+		var sentAt sql.NullTime
+		err := rows.Scan(
+			&m.ID,
+			&m.Mail.To,
+			&m.Mail.From,
+			&m.Mail.Subject,
+			&m.Mail.Content,
+			&m.Mail.PlainContent,
+			&m.Status,
+			&m.Attempts,
+			&m.AttemptsBeforeResend,
+			&m.NextAttemptAt,
+			&m.LastError,
+			&sentAt,
+			&m.CreatedAt,
+			&m.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		m.SentAt = sentAt.Time
+		messages = append(messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// insertMailAttachments saves the attachments of a message added to the outbox
+func insertMailAttachments(ctx context.Context, tx *sql.Tx, bind func(n int) string, mailId int, attachments []models.MailAttachment) error {
+	statement := `insert into mail_attachments (mail_id, name, content_type, data, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `)`
+
+	for _, a := range attachments {
+		_, err := tx.ExecContext(ctx, statement, mailId, a.Name, a.ContentType, a.Data, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMailAttachments reads the attachments of a message
+func loadMailAttachments(ctx context.Context, db *sql.DB, bind func(n int) string, msg *models.MailMessage) error {
+	query := `select name, content_type, data from mail_attachments where mail_id = ` + bind(1) + ` order by id`
+
+	rows, err := db.QueryContext(ctx, query, msg.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.MailAttachment
+		err = rows.Scan(&a.Name, &a.ContentType, &a.Data)
+		if err != nil {
+			return err
+		}
+		msg.Mail.Attachments = append(msg.Mail.Attachments, a)
+	}
+
+	return rows.Err()
+}
+
+// claimMail hands out up to limit messages that are due to be sent, marking them as sending so no
+// other worker takes them too. Messages that have been sending for longer than mailClaimTimeout
+// are handed out again
+func claimMail(ctx context.Context, db *sql.DB, bind func(n int) string, limit int) ([]models.MailMessage, error) {
+	now := time.Now()
+	abandoned := now.Add(-mailClaimTimeout)
+
+	query := `select id from mail_outbox
+		where (status = ` + bind(1) + ` and next_attempt_at <= ` + bind(2) + `)
+		   or (status = ` + bind(3) + ` and updated_at < ` + bind(4) + `)
+		order by next_attempt_at, id
+		limit ` + bind(5)
+
+	rows, err := db.QueryContext(ctx, query, models.MailPending, now, models.MailSending, abandoned, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// another worker may claim the same message in between, and only one of the updates takes it
+	claim := `update mail_outbox set status = ` + bind(1) + `, updated_at = ` + bind(2) + `
+		where id = ` + bind(3) + ` and ((status = ` + bind(4) + ` and next_attempt_at <= ` + bind(5) + `)
+		   or (status = ` + bind(6) + ` and updated_at < ` + bind(7) + `))`
+
+	var messages []models.MailMessage
+	for _, id := range ids {
+		result, err := db.ExecContext(ctx, claim, models.MailSending, now, id, models.MailPending, now, models.MailSending, abandoned)
+		if err != nil {
+			return messages, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return messages, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		msg, err := getMailByID(ctx, db, bind, id)
+		if err != nil {
+			return messages, err
+		}
+		if err = loadMailAttachments(ctx, db, bind, &msg); err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// recordMailAttempt logs an attempt at sending a message and moves it on to status: sent, pending
+// again until nextAttempt, or failed for good
+func recordMailAttempt(ctx context.Context, db *sql.DB, bind func(n int) string, id int, status, errorMessage string, nextAttempt time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attempts int
+	err = tx.QueryRowContext(ctx, `select attempts from mail_outbox where id = `+bind(1), id).Scan(&attempts)
+	if err != nil {
+		return err
+	}
+	attempts++
+
+	var sentAt interface{}
+	if status == models.MailSent {
+		sentAt = time.Now()
+	}
+	if nextAttempt.IsZero() {
+		nextAttempt = time.Now()
+	}
+
+	statement := `update mail_outbox set status = ` + bind(1) + `, attempts = ` + bind(2) + `, next_attempt_at = ` + bind(3) + `,
+		last_error = ` + bind(4) + `, sent_at = ` + bind(5) + `, updated_at = ` + bind(6) + `
+		where id = ` + bind(7)
+	_, err = tx.ExecContext(ctx, statement, status, attempts, nextAttempt, errorMessage, sentAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	delivery := models.MailSent
+	if status != models.MailSent {
+		delivery = models.MailFailed
+	}
+	statement = `insert into mail_deliveries (mail_id, attempt, status, error_message, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `)`
+	_, err = tx.ExecContext(ctx, statement, id, attempts, delivery, errorMessage, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// listMail returns the latest limit messages with status, or with any status when it's empty,
+// newest first and without their attachments or deliveries
+func listMail(ctx context.Context, db *sql.DB, bind func(n int) string, status string, limit int) ([]models.MailMessage, error) {
+	var rows *sql.Rows
+	var err error
+	if status == "" {
+		rows, err = db.QueryContext(ctx, `select `+mailColumns+` from mail_outbox order by id desc limit `+bind(1), limit)
+	} else {
+		query := `select ` + mailColumns + ` from mail_outbox where status = ` + bind(1) + ` order by id desc limit ` + bind(2)
+		rows, err = db.QueryContext(ctx, query, status, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMail(rows)
+}
+
+// getMailByID returns a message with its delivery log, but not its attachments
+func getMailByID(ctx context.Context, db *sql.DB, bind func(n int) string, id int) (models.MailMessage, error) {
+	rows, err := db.QueryContext(ctx, `select `+mailColumns+` from mail_outbox where id = `+bind(1), id)
+	if err != nil {
+		return models.MailMessage{}, err
+	}
+	messages, err := scanMail(rows)
+	if err != nil {
+		return models.MailMessage{}, err
+	}
+	if len(messages) == 0 {
+		return models.MailMessage{}, sql.ErrNoRows
+	}
+	msg := messages[0]
+
+	query := `select id, mail_id, attempt, status, error_message, created_at from mail_deliveries
+		where mail_id = ` + bind(1) + ` order by id`
+	rows, err = db.QueryContext(ctx, query, id)
+	if err != nil {
+		return msg, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.MailDelivery
+		err = rows.Scan(&d.ID, &d.MailId, &d.Attempt, &d.Status, &d.Error, &d.CreatedAt)
+		if err != nil {
+			return msg, err
+		}
+		msg.Deliveries = append(msg.Deliveries, d)
+	}
+
+	return msg, rows.Err()
+}
+
+// resendMail puts a sent or failed message back in the queue, due now. Its attempts keep counting,
+// so deliveries stay numbered in order, and those made so far are set aside as attempts_before_resend
+// for the outbox to give it a fresh set of tries
+func resendMail(ctx context.Context, db *sql.DB, bind func(n int) string, id int) error {
+	var status string
+	err := db.QueryRowContext(ctx, `select status from mail_outbox where id = `+bind(1), id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status == models.MailPending || status == models.MailSending {
+		return repository.ErrMailQueued
+	}
+
+	statement := `update mail_outbox set status = ` + bind(1) + `, attempts_before_resend = attempts, next_attempt_at = ` + bind(2) + `,
+		last_error = '', updated_at = ` + bind(3) + `
+		where id = ` + bind(4) + ` and status = ` + bind(5)
+	result, err := db.ExecContext(ctx, statement, models.MailPending, time.Now(), time.Now(), id, status)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// it was claimed or resent while we looked
+		return repository.ErrMailQueued
+	}
+	return nil
+}
+
+// countMailByStatus returns how many messages the outbox holds in each status
+func countMailByStatus(ctx context.Context, db *sql.DB) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	rows, err := db.QueryContext(ctx, `select status, count(id) from mail_outbox group by status`)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var n int
+		if err = rows.Scan(&status, &n); err != nil {
+			return counts, err
+		}
+		counts[status] = n
+	}
+
+	return counts, rows.Err()
+}
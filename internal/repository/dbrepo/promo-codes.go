@@ -0,0 +1,119 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"time"
+)
+
+// promoCodeColumns are the columns scanPromoCode expects, in order, for promo_codes aliased as pc.
+// Redemptions by cancelled reservations don't count, so cancelling gives the redemption back
+const promoCodeColumns = `pc.id, pc.code, pc.description, pc.discount_type, pc.amount, pc.start_date, pc.end_date,
+		pc.min_nights, pc.max_redemptions, pc.active, pc.created_at, pc.updated_at,
+		(select count(pr.id) from promo_redemptions pr
+		 join reservations r on (pr.reservation_id = r.id)
+		 where pr.promo_code_id = pc.id and r.status <> '` + models.ReservationCancelled + `')`
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPromoCode reads a promo code selected with promoCodeColumns
+func scanPromoCode(row scanner) (models.PromoCode, error) {
+	var c models.PromoCode
+	err := row.Scan(
+		&c.ID,
+		&c.Code,
+		&c.Description,
+		&c.DiscountType,
+		&c.Amount,
+		&c.StartDate,
+		&c.EndDate,
+		&c.MinNights,
+		&c.MaxRedemptions,
+		&c.Active,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.Redemptions,
+	)
+	return c, err
+}
+
+// promoCodeRooms returns the ids of the rooms a promo code is limited to
+func promoCodeRooms(ctx context.Context, db *sql.DB, bind func(n int) string, promoCodeId int) ([]int, error) {
+	var roomIds []int
+
+	rows, err := db.QueryContext(ctx, `select room_id from promo_code_rooms where promo_code_id = `+bind(1)+` order by room_id`, promoCodeId)
+	if err != nil {
+		return roomIds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return roomIds, err
+		}
+		roomIds = append(roomIds, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return roomIds, err
+	}
+
+	return roomIds, nil
+}
+
+// replacePromoCodeRooms swaps the rooms a promo code is limited to for the given ones
+func replacePromoCodeRooms(ctx context.Context, tx *sql.Tx, bind func(n int) string, promoCodeId int, roomIds []int) error {
+	_, err := tx.ExecContext(ctx, `delete from promo_code_rooms where promo_code_id = `+bind(1), promoCodeId)
+	if err != nil {
+		return err
+	}
+
+	statement := `insert into promo_code_rooms (promo_code_id, room_id, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `)`
+
+	for _, roomId := range roomIds {
+		_, err = tx.ExecContext(ctx, statement, promoCodeId, roomId, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redeemPromoCode records that a new reservation used its promo code, unless the code has no
+// redemptions left. lock is appended to the select of the code, to lock its row where the dialect can
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, bind func(n int) string, lock string, reservation models.Reservation, reservationId int) error {
+	var maxRedemptions int
+	err := tx.QueryRowContext(ctx, `select max_redemptions from promo_codes where id = `+bind(1)+lock,
+		reservation.PromoCodeId).Scan(&maxRedemptions)
+	if err != nil {
+		return err
+	}
+
+	if maxRedemptions > 0 {
+		var redemptions int
+		query := `select count(pr.id) from promo_redemptions pr
+			join reservations r on (pr.reservation_id = r.id)
+			where pr.promo_code_id = ` + bind(1) + ` and r.status <> ` + bind(2)
+
+		err = tx.QueryRowContext(ctx, query, reservation.PromoCodeId, models.ReservationCancelled).Scan(&redemptions)
+		if err != nil {
+			return err
+		}
+		if redemptions >= maxRedemptions {
+			return repository.ErrPromoCodeUsedUp
+		}
+	}
+
+	statement := `insert into promo_redemptions (promo_code_id, reservation_id, discount, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `)`
+
+	_, err = tx.ExecContext(ctx, statement, reservation.PromoCodeId, reservationId, reservation.Discount, time.Now(), time.Now())
+	return err
+}
@@ -0,0 +1,111 @@
+package dbrepo
+
+import (
+	"fmt"
+	"github.com/Seician/bookings/internal/models"
+	"strings"
+)
+
+// reservationSortColumns maps the sort keys accepted from the admin list to SQL columns
+var reservationSortColumns = map[string]string{
+	"id":         "r.id",
+	"name":       "r.last_name",
+	"email":      "r.email",
+	"room":       "rm.room_name",
+	"start_date": "r.start_date",
+	"end_date":   "r.end_date",
+	"created_at": "r.created_at",
+}
+
+// Defaults for paging through reservations
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// questionMark is the placeholder style of mysql and sqlite
+func questionMark(n int) string {
+	return "?"
+}
+
+// dollarN is the placeholder style of postgres
+func dollarN(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// listReservationsQuery builds the page query and the count query for a reservation
+// filter; bind returns the placeholder for the n-th argument in the dialect
+func listReservationsQuery(f models.ReservationFilter, bind func(n int) string) (string, string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return bind(len(args))
+	}
+
+	// stays that overlap the requested range
+	if !f.From.IsZero() {
+		where = append(where, "r.end_date >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "r.start_date <= "+arg(f.To))
+	}
+	if f.RoomId > 0 {
+		where = append(where, "r.room_id = "+arg(f.RoomId))
+	}
+	switch f.Status {
+	case "":
+	case "new":
+		where = append(where, "r.processed = 0")
+	case "processed":
+		where = append(where, "r.processed = 1")
+	default:
+		for _, status := range models.ReservationStatuses {
+			if f.Status == status {
+				where = append(where, "r.status = "+arg(status))
+			}
+		}
+	}
+	if guest := strings.TrimSpace(f.Guest); guest != "" {
+		like := "%" + strings.ToLower(guest) + "%"
+		where = append(where, fmt.Sprintf("(lower(r.first_name) like %s or lower(r.last_name) like %s or lower(r.email) like %s)",
+			arg(like), arg(like), arg(like)))
+	}
+
+	from := `
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)`
+	if len(where) > 0 {
+		from += "\n\t\twhere " + strings.Join(where, " and ")
+	}
+
+	countQuery := "select count(r.id)" + from
+
+	sort, ok := reservationSortColumns[f.Sort]
+	if !ok {
+		sort = reservationSortColumns["start_date"]
+	}
+	direction := "asc"
+	if f.Descending {
+		direction = "desc"
+	}
+
+	perPage := f.PerPage
+	if perPage <= 0 || perPage > maxPerPage {
+		perPage = defaultPerPage
+	}
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+		       r.room_id, r.created_at, r.updated_at, r.processed, r.status, r.cancel_token, r.total_price, coalesce(rm.id, 0), coalesce(rm.room_name, '')` +
+		from + fmt.Sprintf(`
+		order by %s %s, r.id %s
+		limit %d offset %d`, sort, direction, direction, perPage, (page-1)*perPage)
+
+	return query, countQuery, args
+}
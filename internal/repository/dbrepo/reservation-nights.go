@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"time"
+)
+
+// reservationNights returns the nights of a reservation in date order, priced as they were booked
+func reservationNights(ctx context.Context, db *sql.DB, bind func(n int) string, reservationId int) ([]models.ReservationNight, error) {
+	var nights []models.ReservationNight
+
+	query := `select night, rate, weekend, season from reservation_nights
+		where reservation_id = ` + bind(1) + ` order by night`
+
+	rows, err := db.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return nights, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.ReservationNight
+		err := rows.Scan(&n.Date, &n.Rate, &n.Weekend, &n.Season)
+		if err != nil {
+			return nights, err
+		}
+		nights = append(nights, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nights, err
+	}
+
+	return nights, nil
+}
+
+// replaceReservationNights swaps the nights stored for a reservation for the given ones
+func replaceReservationNights(ctx context.Context, tx *sql.Tx, bind func(n int) string, reservationId int, nights []models.ReservationNight) error {
+	_, err := tx.ExecContext(ctx, `delete from reservation_nights where reservation_id = `+bind(1), reservationId)
+	if err != nil {
+		return err
+	}
+
+	statement := `insert into reservation_nights (reservation_id, night, rate, weekend, season, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `, ` + bind(7) + `)`
+
+	for _, n := range nights {
+		_, err = tx.ExecContext(ctx, statement, reservationId, n.Date, n.Rate, n.Weekend, n.Season, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"time"
+)
+
+// createBlock inserts a block in a transaction, checking the room is free first the way
+// CreateReservation does, so a booking made at the same time can't land on the blocked days. lock is
+// appended to the select of the room, to lock its row where the dialect can
+func createBlock(ctx context.Context, db *sql.DB, bind func(n int) string, lock string, block models.RoomRestriction) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room row so concurrent bookings for the same room wait for this one
+	var roomId int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = `+bind(1)+lock, block.RoomId).Scan(&roomId)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+		where room_id = ` + bind(1) + ` and ` + bind(2) + ` < end_date and ` + bind(3) + ` > start_date`
+
+	err = tx.QueryRowContext(ctx, query, block.RoomId, block.StartDate, block.EndDate).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	statement := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			created_at, updated_at, restriction_id)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, null, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `)`
+
+	_, err = tx.ExecContext(ctx, statement,
+		block.StartDate,
+		block.EndDate,
+		block.RoomId,
+		time.Now(),
+		time.Now(),
+		block.RestrictionId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
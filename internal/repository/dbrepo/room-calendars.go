@@ -0,0 +1,162 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"time"
+)
+
+// roomCalendarColumns are the columns scanRoomCalendars expects, in order
+const roomCalendarColumns = `id, room_id, name, url, last_synced_at, last_error, created_at, updated_at`
+
+// scanRoomCalendars reads room calendars selected with roomCalendarColumns and closes rows
+func scanRoomCalendars(rows *sql.Rows) ([]models.RoomCalendar, error) {
+	defer rows.Close()
+
+	var calendars []models.RoomCalendar
+
+	for rows.Next() {
+		var c models.RoomCalendar
+		var lastSynced sql.NullTime
+		err := rows.Scan(
+			&c.ID,
+			&c.RoomId,
+			&c.Name,
+			&c.URL,
+			&lastSynced,
+			&c.LastError,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return calendars, err
+		}
+		c.LastSyncedAt = lastSynced.Time
+		calendars = append(calendars, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return calendars, err
+	}
+
+	return calendars, nil
+}
+
+// syncRoomCalendar makes the blocks imported from a room calendar match blocks, the events now in
+// its feed: blocks are matched on their ExternalUID, added when new, moved when their dates changed
+// and removed once they are gone from the feed
+func syncRoomCalendar(ctx context.Context, db *sql.DB, bind func(n int) string, cal models.RoomCalendar, blocks []models.RoomRestriction) (models.CalendarSync, error) {
+	var result models.CalendarSync
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	query := `select id, external_uid, start_date, end_date from room_restrictions where room_calendar_id = ` + bind(1)
+	rows, err := tx.QueryContext(ctx, query, cal.ID)
+	if err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err = rows.Scan(&rr.ID, &rr.ExternalUID, &rr.StartDate, &rr.EndDate)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		existing[rr.ExternalUID] = rr
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	insert := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, room_calendar_id,
+		external_uid, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `, ` + bind(7) + `, ` + bind(8) + `)`
+	update := `update room_restrictions set start_date = ` + bind(1) + `, end_date = ` + bind(2) + `, updated_at = ` + bind(3) + `
+		where id = ` + bind(4)
+
+	for _, b := range blocks {
+		old, ok := existing[b.ExternalUID]
+		if !ok {
+			_, err = tx.ExecContext(ctx, insert, b.StartDate, b.EndDate, cal.RoomId, models.RestrictionExternal,
+				cal.ID, b.ExternalUID, time.Now(), time.Now())
+			if err != nil {
+				return result, err
+			}
+			result.Added++
+			continue
+		}
+
+		delete(existing, b.ExternalUID)
+		if old.StartDate.Equal(b.StartDate) && old.EndDate.Equal(b.EndDate) {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, update, b.StartDate, b.EndDate, time.Now(), old.ID)
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	// whatever is left has been cancelled or has ended on the other channel
+	for _, gone := range existing {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = `+bind(1), gone.ID)
+		if err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	return result, tx.Commit()
+}
+
+// deleteRoomCalendar removes a room calendar along with the blocks imported from it. The foreign key
+// cascades to them, but SQLite databases built before foreign keys were enforced have none
+func deleteRoomCalendar(ctx context.Context, db *sql.DB, bind func(n int) string, roomId, id int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from room_calendars where id = `+bind(1)+` and room_id = `+bind(2), id, roomId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where room_calendar_id = `+bind(1), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateRoomCalendarStatus records how the last sync of a room calendar went: the time it worked,
+// or the error it failed with, keeping the time of the last sync that worked
+func updateRoomCalendarStatus(ctx context.Context, db *sql.DB, bind func(n int) string, id int, lastError string) error {
+	var err error
+	if lastError == "" {
+		statement := `update room_calendars set last_synced_at = ` + bind(1) + `, last_error = '', updated_at = ` + bind(2) + `
+			where id = ` + bind(3)
+		_, err = db.ExecContext(ctx, statement, time.Now(), time.Now(), id)
+	} else {
+		statement := `update room_calendars set last_error = ` + bind(1) + `, updated_at = ` + bind(2) + ` where id = ` + bind(3)
+		_, err = db.ExecContext(ctx, statement, lastError, time.Now(), id)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+	"strings"
+	"time"
+)
+
+// roomPhotos returns the photos of a room in display order; bind returns the placeholder
+// for the n-th argument in the dialect
+func roomPhotos(ctx context.Context, db *sql.DB, bind func(n int) string, roomId int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+
+	query := `select id, room_id, file_name, caption, position from room_photos
+		where room_id = ` + bind(1) + ` order by position, id`
+
+	rows, err := db.QueryContext(ctx, query, roomId)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(&p.ID, &p.RoomId, &p.FileName, &p.Caption, &p.Position)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+
+	return photos, nil
+}
+
+// replaceRoomPhotos swaps the photos of a room for the given ones, numbering them in order
+func replaceRoomPhotos(ctx context.Context, tx *sql.Tx, bind func(n int) string, roomId int, photos []models.RoomPhoto) error {
+	_, err := tx.ExecContext(ctx, `delete from room_photos where room_id = `+bind(1), roomId)
+	if err != nil {
+		return err
+	}
+
+	statement := `insert into room_photos (room_id, file_name, caption, position, created_at, updated_at)
+		values (` + bind(1) + `, ` + bind(2) + `, ` + bind(3) + `, ` + bind(4) + `, ` + bind(5) + `, ` + bind(6) + `)`
+
+	for i, p := range photos {
+		_, err = tx.ExecContext(ctx, statement, roomId, p.FileName, p.Caption, i+1, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinLines stores a list, like the amenities of a room, as one line per item
+func joinLines(items []string) string {
+	return strings.Join(items, "\n")
+}
+
+// splitLines reads back a list stored by joinLines, skipping blank lines
+func splitLines(s string) []string {
+	var items []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
@@ -0,0 +1,41 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"github.com/Seician/bookings/internal/models"
+)
+
+// roomRateColumns are the columns scanRoomRates expects, in order
+const roomRateColumns = `id, room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at`
+
+// scanRoomRates reads seasonal rates selected with roomRateColumns and closes rows
+func scanRoomRates(rows *sql.Rows) ([]models.RoomRate, error) {
+	defer rows.Close()
+
+	var rates []models.RoomRate
+
+	for rows.Next() {
+		var r models.RoomRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomId,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyRate,
+			&r.WeekendRate,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
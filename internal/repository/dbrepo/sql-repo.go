@@ -0,0 +1,1455 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+func (m *sqlDBRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+//InsertReservation insert reservation into the database
+func (m *sqlDBRepo) InsertReservation(ctx context.Context, reservation models.Reservation) (int64, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	statement := `INSERT INTO reservations (first_name, last_name, email, phone,
+					start_date, end_date, room_id, status, cancel_token, total_price, deposit, payment_id, payment_status,
+					created_at, updated_at)
+					values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	newId, err := m.insertID(ctx, m.DB, statement,
+		reservation.FirstName,
+		reservation.LastName,
+		reservation.Email,
+		reservation.Phone,
+		reservation.StartDate,
+		reservation.EndDate,
+		reservation.RoomId,
+		reservationStatus(reservation),
+		reservation.CancelToken,
+		reservation.TotalPrice,
+		reservation.Deposit,
+		reservation.PaymentId,
+		reservation.PaymentStatus,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return int64(newId), nil
+}
+
+// InsertRoomRestriction inserts a room restriction into the database
+func (m *sqlDBRepo) InsertRoomRestriction(ctx context.Context, restriction models.RoomRestriction) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	statement := `INSERT INTO room_restrictions(start_date, end_date, room_id, reservation_id,
+					created_at, updated_at, restriction_id) values (?,?,?,?,?,?,?)`
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(statement),
+		restriction.StartDate,
+		restriction.EndDate,
+		restriction.RoomId,
+		nullableID(restriction.ReservationId),
+		time.Now(),
+		time.Now(),
+		restriction.RestrictionId)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateReservation inserts a reservation and its room restriction in a single
+// transaction, re-checking availability first so overlapping bookings can't both succeed
+func (m *sqlDBRepo) CreateReservation(ctx context.Context, reservation models.Reservation) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the room row so concurrent bookings for the same room wait for this one, and so it
+	// can't be deactivated meanwhile
+	var active bool
+	err = tx.QueryRowContext(ctx, m.rebind(`select active from rooms where id = ?`+m.lock), reservation.RoomId).Scan(&active)
+	if err != nil {
+		return 0, err
+	}
+	if !active {
+		return 0, repository.ErrRoomInactive
+	}
+
+	var numRows int
+	query := `SELECT
+    				count(id)
+			   FROM
+			   	    room_restrictions
+                 WHERE
+                     room_id = ? AND
+                     ? < end_date AND ? > start_date`
+
+	err = tx.QueryRowContext(ctx, m.rebind(query), reservation.RoomId, reservation.StartDate, reservation.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	statement := `INSERT INTO reservations (first_name, last_name, email, phone,
+					start_date, end_date, room_id, status, cancel_token, total_price, deposit, payment_id, payment_status,
+					created_at, updated_at)
+					values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	newId, err := m.insertID(ctx, tx, statement,
+		reservation.FirstName,
+		reservation.LastName,
+		reservation.Email,
+		reservation.Phone,
+		reservation.StartDate,
+		reservation.EndDate,
+		reservation.RoomId,
+		reservationStatus(reservation),
+		reservation.CancelToken,
+		reservation.TotalPrice,
+		reservation.Deposit,
+		reservation.PaymentId,
+		reservation.PaymentStatus,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	statement = `INSERT INTO room_restrictions(start_date, end_date, room_id, reservation_id,
+					created_at, updated_at, restriction_id) values (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = tx.ExecContext(ctx, m.rebind(statement),
+		reservation.StartDate,
+		reservation.EndDate,
+		reservation.RoomId,
+		newId,
+		time.Now(),
+		time.Now(),
+		models.RestrictionReservation)
+	if err != nil {
+		return 0, err
+	}
+
+	err = replaceReservationNights(ctx, tx, m.bind, newId, reservation.Nights)
+	if err != nil {
+		return 0, err
+	}
+
+	if reservation.PromoCodeId > 0 {
+		err = redeemPromoCode(ctx, tx, m.bind, m.lock, reservation, newId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+//SearchAvailabilityByDatesByRoomId returns true if availability exists for roomId, and false if no availability exists.
+//A deactivated room is never available
+func (m *sqlDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var active bool
+	err := m.DB.QueryRowContext(ctx, m.rebind(`select active from rooms where id = ?`), roomId).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !active {
+		return false, nil
+	}
+
+	var numRows int
+
+	query := `SELECT 
+    				count(id)
+			   FROM
+			   	    room_restrictions
+                 WHERE 
+                     room_id = ? AND
+                     ? < end_date AND ? > start_date`
+
+	row := m.DB.QueryRowContext(ctx, m.rebind(query), roomId, start, end)
+	err = row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+	if numRows == 0 {
+		return true, nil
+	}
+	return false, nil
+}
+
+//SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
+func (m *sqlDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `SELECT 
+    				r.id, r.room_name, r.base_rate, r.weekend_rate
+			   FROM
+			   	    rooms r
+                 WHERE 
+                     r.active = true AND
+                     r.id not in
+                     (select room_id from room_restrictions rr where ? < rr.end_date and ? > rr.start_date);`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), start, end)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.BaseRate,
+			&room.WeekendRate,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetRoomById returns a room by id, with its photos
+func (m *sqlDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var room models.Room
+	var amenities string
+
+	query := `select id, room_name, slug, description, amenities, capacity, active, base_rate, weekend_rate,
+		created_at, updated_at from rooms where id = ?`
+
+	row := m.DB.QueryRowContext(ctx, m.rebind(query), id)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&amenities,
+		&room.Capacity,
+		&room.Active,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+	room.Amenities = splitLines(amenities)
+
+	room.Photos, err = roomPhotos(ctx, m.DB, m.bind, room.ID)
+	if err != nil {
+		return room, err
+	}
+	return room, nil
+}
+
+// GetRoomBySlug returns the room shown at /rooms/{slug}
+func (m *sqlDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var id int
+
+	err := m.DB.QueryRowContext(ctx, m.rebind(`select id from rooms where slug = ?`), slug).Scan(&id)
+	if err != nil {
+		return models.Room{}, err
+	}
+
+	return m.GetRoomById(ctx, id)
+}
+
+// GetUserByID returns a user by id
+func (m *sqlDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+from users where id = ?`
+
+	row := m.DB.QueryRowContext(ctx, m.rebind(query), id)
+
+	var u models.User
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// UpdateUser updates a user in the database
+func (m *sqlDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `
+update users set first_name = ?, last_name=?, email=?, access_level=?, updated_at=? where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(query),
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+		u.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Authenticate the user
+func (m *sqlDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, m.rebind("select id, password from users where email = ?"), email)
+
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// ListReservations returns one page of reservations matching the filter, with their
+// room, and the total number of matching reservations
+func (m *sqlDBRepo) ListReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var reservations []models.Reservation
+	var total int
+
+	query, countQuery, args := listReservationsQuery(filter, m.bind)
+
+	err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	if err != nil {
+		return reservations, 0, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomId,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Status,
+			&i.CancelToken,
+			&i.TotalPrice,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, 0, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, 0, err
+	}
+
+	return reservations, total, nil
+}
+
+// AllRooms returns all rooms ordered by name
+func (m *sqlDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `select id, room_name, slug, description, capacity, active, base_rate, weekend_rate, created_at, updated_at
+		from rooms order by room_name`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query))
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.Slug,
+			&room.Description,
+			&room.Capacity,
+			&room.Active,
+			&room.BaseRate,
+			&room.WeekendRate,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetReservationByID returns one reservation, with its room and the nights it was booked at
+func (m *sqlDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+		       r.room_id, r.created_at, r.updated_at, r.processed, r.status, r.cancel_token, r.total_price,
+		       r.deposit, r.payment_id, r.payment_status,
+		       coalesce(pc.id, 0), coalesce(pc.code, ''), coalesce(pr.discount, 0), rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join promo_redemptions pr on (pr.reservation_id = r.id)
+		left join promo_codes pc on (pr.promo_code_id = pc.id)
+		where r.id = ?`
+
+	row := m.DB.QueryRowContext(ctx, m.rebind(query), id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomId,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.Status,
+		&res.CancelToken,
+		&res.TotalPrice,
+		&res.Deposit,
+		&res.PaymentId,
+		&res.PaymentStatus,
+		&res.PromoCodeId,
+		&res.PromoCode,
+		&res.Discount,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+
+	res.Nights, err = reservationNights(ctx, m.DB, m.bind, res.ID)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// UpdateReservation saves the guest details, dates, room and nights of a reservation, moving its
+// room restriction along in the same transaction once the new room and dates are known to be free
+func (m *sqlDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room row so concurrent bookings for the same room wait for this one
+	var roomId int
+	err = tx.QueryRowContext(ctx, m.rebind(`select id from rooms where id = ?`+m.lock), res.RoomId).Scan(&roomId)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+		select count(id) from room_restrictions
+		where room_id = ? and ? < end_date and ? > start_date
+		and (reservation_id is null or reservation_id <> ?)`
+
+	err = tx.QueryRowContext(ctx, m.rebind(query), res.RoomId, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	statement := `
+		update reservations set first_name = ?, last_name = ?, email = ?, phone = ?,
+		start_date = ?, end_date = ?, room_id = ?, total_price = ?, updated_at = ?
+		where id = ?`
+
+	_, err = tx.ExecContext(ctx, m.rebind(statement),
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomId,
+		res.TotalPrice,
+		time.Now(),
+		res.ID)
+	if err != nil {
+		return err
+	}
+
+	statement = `
+		update room_restrictions set start_date = ?, end_date = ?, room_id = ?, updated_at = ?
+		where reservation_id = ?`
+
+	_, err = tx.ExecContext(ctx, m.rebind(statement),
+		res.StartDate,
+		res.EndDate,
+		res.RoomId,
+		time.Now(),
+		res.ID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceReservationNights(ctx, tx, m.bind, res.ID, res.Nights)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateProcessedForReservation marks a reservation as processed (1) or new (0)
+func (m *sqlDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update reservations set processed = ?, updated_at = ? where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(query), processed, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetReservationByToken returns the reservation a guest's cancellation link points at
+func (m *sqlDBRepo) GetReservationByToken(ctx context.Context, token string) (models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var id int
+
+	// reservations made before tokens existed have an empty one and can't be looked up
+	if token == "" {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	err := m.DB.QueryRowContext(ctx, m.rebind(`select id from reservations where cancel_token = ?`), token).Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationByID(ctx, id)
+}
+
+// InvoiceForReservation returns the invoice of draft.ReservationId, issuing draft under the next
+// invoice number the first time it's asked for
+func (m *sqlDBRepo) InvoiceForReservation(ctx context.Context, draft models.Invoice) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return invoiceForReservation(ctx, m.DB, m.bind, draft)
+}
+
+// UpdatePaymentStatus records what the payment provider says happened to the deposit of a reservation
+func (m *sqlDBRepo) UpdatePaymentStatus(ctx context.Context, paymentId, status string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update reservations set payment_status = ?, updated_at = ? where payment_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.rebind(query), status, time.Now(), paymentId)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateReservationStatus moves a reservation to a new status if models.ReservationTransitions
+// allows it; cancelling goes through CancelReservation, which also releases the dates
+func (m *sqlDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	if status == models.ReservationCancelled {
+		err := m.CancelReservation(ctx, id)
+		if errors.Is(err, repository.ErrNotCancellable) {
+			return repository.ErrInvalidStatusTransition
+		}
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, m.rebind(`select status from reservations where id = ?`+m.lock), id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !models.CanChangeStatus(current, status) {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	_, err = tx.ExecContext(ctx, m.rebind(`update reservations set status = ?, updated_at = ? where id = ?`),
+		status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation cancels a reservation and deletes its room restriction so the dates can be booked again
+func (m *sqlDBRepo) CancelReservation(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, m.rebind(`select status from reservations where id = ?`+m.lock), id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != models.ReservationPending && status != models.ReservationConfirmed {
+		return repository.ErrNotCancellable
+	}
+
+	_, err = tx.ExecContext(ctx, m.rebind(`update reservations set status = ?, updated_at = ? where id = ?`),
+		models.ReservationCancelled, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, m.rebind(`delete from room_restrictions where reservation_id = ?`), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions of a room that overlap the days from start up to end
+func (m *sqlDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0),
+		       rr.restriction_id, coalesce(r.restriction_name, '')
+		from room_restrictions rr
+		left join restrictions r on (rr.restriction_id = r.id)
+		where rr.room_id = ? and rr.start_date < ? and rr.end_date >= ?
+		order by rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), roomId, end, start)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.RoomId,
+			&rr.ReservationId,
+			&rr.RestrictionId,
+			&rr.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		rr.Restriction.ID = rr.RestrictionId
+		restrictions = append(restrictions, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// AllRestrictions returns the kinds of restriction a room can have
+func (m *sqlDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var restrictions []models.Restriction
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(`select id, restriction_name, created_at, updated_at from restrictions order by id`))
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Restriction
+		err := rows.Scan(&r.ID, &r.RestrictionName, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// ListBlocks returns the owner blocks, maintenance, closures and external bookings of all rooms that end on or after from
+func (m *sqlDBRepo) ListBlocks(ctx context.Context, from time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var blocks []models.RoomRestriction
+
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, rr.restriction_id, coalesce(rr.room_calendar_id, 0),
+		       coalesce(r.restriction_name, ''), coalesce(rm.room_name, '')
+		from room_restrictions rr
+		left join restrictions r on (rr.restriction_id = r.id)
+		left join rooms rm on (rr.room_id = rm.id)
+		where rr.reservation_id is null and rr.end_date > ?
+		order by rr.start_date, rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), from)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.RoomId,
+			&rr.RestrictionId,
+			&rr.RoomCalendarId,
+			&rr.Restriction.RestrictionName,
+			&rr.Room.RoomName,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		rr.Restriction.ID = rr.RestrictionId
+		rr.Room.ID = rr.RoomId
+		blocks = append(blocks, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+// DeleteBlockByID removes a block; restrictions that belong to a reservation or come from a room
+// calendar, which the next sync would bring back, are left alone
+func (m *sqlDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, m.rebind(`delete from room_restrictions where id = ? and reservation_id is null and room_calendar_id is null`), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateBlock adds an owner stay, maintenance or closure block, returning
+// repository.ErrRoomUnavailable when the room is already reserved or blocked on some of its days
+func (m *sqlDBRepo) CreateBlock(ctx context.Context, block models.RoomRestriction) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return createBlock(ctx, m.DB, m.bind, m.lock, block)
+}
+
+// InsertRoom adds a room with its photos and returns its id
+func (m *sqlDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	statement := `insert into rooms (room_name, slug, description, amenities, capacity, active, base_rate, weekend_rate,
+		created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	newId, err := m.insertID(ctx, tx, statement,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		joinLines(room.Amenities),
+		room.Capacity,
+		room.Active,
+		room.BaseRate,
+		room.WeekendRate,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	err = replaceRoomPhotos(ctx, tx, m.bind, newId, room.Photos)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+// UpdateRoom saves the details and photos of a room
+func (m *sqlDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement := `update rooms set room_name = ?, slug = ?, description = ?, amenities = ?, capacity = ?,
+		active = ?, base_rate = ?, weekend_rate = ?, updated_at = ?
+		where id = ?`
+
+	_, err = tx.ExecContext(ctx, m.rebind(statement),
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		joinLines(room.Amenities),
+		room.Capacity,
+		room.Active,
+		room.BaseRate,
+		room.WeekendRate,
+		time.Now(),
+		room.ID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRoomPhotos(ctx, tx, m.bind, room.ID, room.Photos)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRoom deletes a room that was never booked. Reservations keep their room, so one that has
+// any, even past or cancelled ones, can only be deactivated
+func (m *sqlDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, m.rebind(`select count(id) from reservations where room_id = ?`), id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomHasReservations
+	}
+
+	result, err := tx.ExecContext(ctx, m.rebind(`delete from rooms where id = ?`), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomRates returns the seasonal rates of a room, in date order
+func (m *sqlDBRepo) GetRoomRates(ctx context.Context, roomId int) ([]models.RoomRate, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomRateColumns + ` from room_rates where room_id = ? order by start_date, id`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoomRates(rows)
+}
+
+// GetRoomRatesByDate returns the seasonal rates of a room that cover any night from start up to end.
+// Rates are in date order, so where seasons overlap the one starting last comes last
+func (m *sqlDBRepo) GetRoomRatesByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRate, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomRateColumns + ` from room_rates
+		where room_id = ? and start_date < ? and end_date >= ?
+		order by start_date, id`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), roomId, end, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoomRates(rows)
+}
+
+// InsertRoomRate adds a seasonal rate for a room
+func (m *sqlDBRepo) InsertRoomRate(ctx context.Context, rate models.RoomRate) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	statement := `insert into room_rates (room_id, name, start_date, end_date, nightly_rate, weekend_rate,
+		created_at, updated_at) values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(statement),
+		rate.RoomId,
+		rate.Name,
+		rate.StartDate,
+		rate.EndDate,
+		rate.NightlyRate,
+		rate.WeekendRate,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoomRate removes a seasonal rate of a room
+func (m *sqlDBRepo) DeleteRoomRate(ctx context.Context, roomId, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, m.rebind(`delete from room_rates where id = ? and room_id = ?`), id, roomId)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AllRoomCalendars returns the calendars of every room, for the sync job
+func (m *sqlDBRepo) AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + ` from room_calendars
+		where room_id in (select id from rooms)
+		order by room_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoomCalendars(rows)
+}
+
+// GetRoomCalendars returns the calendars a room imports blocks from
+func (m *sqlDBRepo) GetRoomCalendars(ctx context.Context, roomId int) ([]models.RoomCalendar, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + ` from room_calendars where room_id = ? order by id`
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(query), roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRoomCalendars(rows)
+}
+
+// GetRoomCalendarByID returns a room calendar by id
+func (m *sqlDBRepo) GetRoomCalendarByID(ctx context.Context, id int) (models.RoomCalendar, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(`select `+roomCalendarColumns+` from room_calendars where id = ?`), id)
+	if err != nil {
+		return models.RoomCalendar{}, err
+	}
+
+	calendars, err := scanRoomCalendars(rows)
+	if err != nil {
+		return models.RoomCalendar{}, err
+	}
+	if len(calendars) == 0 {
+		return models.RoomCalendar{}, sql.ErrNoRows
+	}
+	return calendars[0], nil
+}
+
+// InsertRoomCalendar adds a calendar for a room to import blocks from
+func (m *sqlDBRepo) InsertRoomCalendar(ctx context.Context, cal models.RoomCalendar) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	statement := `insert into room_calendars (room_id, name, url, last_error, created_at, updated_at)
+		values (?, ?, ?, '', ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(statement), cal.RoomId, cal.Name, cal.URL, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoomCalendar removes a calendar of a room and the blocks imported from it
+func (m *sqlDBRepo) DeleteRoomCalendar(ctx context.Context, roomId, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return deleteRoomCalendar(ctx, m.DB, m.bind, roomId, id)
+}
+
+// SyncRoomCalendar replaces the blocks imported from a room calendar with blocks
+func (m *sqlDBRepo) SyncRoomCalendar(ctx context.Context, cal models.RoomCalendar, blocks []models.RoomRestriction) (models.CalendarSync, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return syncRoomCalendar(ctx, m.DB, m.bind, cal, blocks)
+}
+
+// UpdateRoomCalendarStatus records the outcome of the last sync of a room calendar, an empty
+// lastError meaning it worked
+func (m *sqlDBRepo) UpdateRoomCalendarStatus(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return updateRoomCalendarStatus(ctx, m.DB, m.bind, id, lastError)
+}
+
+// AllPromoCodes returns every promo code, newest first, without the rooms they are limited to
+func (m *sqlDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(`select `+promoCodeColumns+` from promo_codes pc order by pc.start_date desc, pc.code`))
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code with the rooms it is limited to
+func (m *sqlDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	c, err := scanPromoCode(m.DB.QueryRowContext(ctx, m.rebind(`select `+promoCodeColumns+` from promo_codes pc where pc.id = ?`), id))
+	if err != nil {
+		return c, err
+	}
+
+	c.RoomIds, err = promoCodeRooms(ctx, m.DB, m.bind, c.ID)
+	if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// GetPromoCodeByCode returns the promo code a guest entered; codes are stored in upper case
+func (m *sqlDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var id int
+
+	err := m.DB.QueryRowContext(ctx, m.rebind(`select id from promo_codes where code = ?`), strings.ToUpper(code)).Scan(&id)
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+
+	return m.GetPromoCodeByID(ctx, id)
+}
+
+// InsertPromoCode adds a promo code and returns its id
+func (m *sqlDBRepo) InsertPromoCode(ctx context.Context, code models.PromoCode) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	statement := `insert into promo_codes (code, description, discount_type, amount, start_date, end_date,
+		min_nights, max_redemptions, active, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	newId, err := m.insertID(ctx, tx, statement,
+		strings.ToUpper(code.Code),
+		code.Description,
+		code.DiscountType,
+		code.Amount,
+		code.StartDate,
+		code.EndDate,
+		code.MinNights,
+		code.MaxRedemptions,
+		code.Active,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+	err = replacePromoCodeRooms(ctx, tx, m.bind, newId, code.RoomIds)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+// UpdatePromoCode saves a promo code and the rooms it is limited to
+func (m *sqlDBRepo) UpdatePromoCode(ctx context.Context, code models.PromoCode) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement := `update promo_codes set code = ?, description = ?, discount_type = ?, amount = ?,
+		start_date = ?, end_date = ?, min_nights = ?, max_redemptions = ?, active = ?, updated_at = ?
+		where id = ?`
+
+	_, err = tx.ExecContext(ctx, m.rebind(statement),
+		strings.ToUpper(code.Code),
+		code.Description,
+		code.DiscountType,
+		code.Amount,
+		code.StartDate,
+		code.EndDate,
+		code.MinNights,
+		code.MaxRedemptions,
+		code.Active,
+		time.Now(),
+		code.ID)
+	if err != nil {
+		return err
+	}
+
+	err = replacePromoCodeRooms(ctx, tx, m.bind, code.ID, code.RoomIds)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePromoCode deletes a promo code no booking has used yet
+func (m *sqlDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, m.rebind(`select count(id) from promo_redemptions where promo_code_id = ?`), id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrPromoCodeRedeemed
+	}
+
+	_, err = tx.ExecContext(ctx, m.rebind(`delete from promo_code_rooms where promo_code_id = ?`), id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, m.rebind(`delete from promo_codes where id = ?`), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// InsertMail adds a message to the outbox, due to be sent straight away, and returns its id
+func (m *sqlDBRepo) InsertMail(ctx context.Context, msg models.MailData) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	statement := `insert into mail_outbox (to_address, from_address, subject, content, plain_content, status,
+		attempts, next_attempt_at, last_error, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, 0, ?, '', ?, ?)`
+
+	newId, err := m.insertID(ctx, tx, statement,
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.PlainContent,
+		models.MailPending,
+		time.Now(),
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertMailAttachments(ctx, tx, m.bind, newId, msg.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+// ClaimMail hands out up to limit messages that are due to be sent, marking them as sending
+func (m *sqlDBRepo) ClaimMail(ctx context.Context, limit int) ([]models.MailMessage, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return claimMail(ctx, m.DB, m.bind, limit)
+}
+
+// RecordMailAttempt logs an attempt at sending a message and moves it on to status
+func (m *sqlDBRepo) RecordMailAttempt(ctx context.Context, id int, status, errorMessage string, nextAttempt time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return recordMailAttempt(ctx, m.DB, m.bind, id, status, errorMessage, nextAttempt)
+}
+
+// ListMail returns the latest messages in the outbox, all of them or those with status
+func (m *sqlDBRepo) ListMail(ctx context.Context, status string, limit int) ([]models.MailMessage, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listMail(ctx, m.DB, m.bind, status, limit)
+}
+
+// GetMailByID returns a message in the outbox with its delivery log
+func (m *sqlDBRepo) GetMailByID(ctx context.Context, id int) (models.MailMessage, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getMailByID(ctx, m.DB, m.bind, id)
+}
+
+// ResendMail puts a sent or failed message back in the queue
+func (m *sqlDBRepo) ResendMail(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return resendMail(ctx, m.DB, m.bind, id)
+}
+
+// CountMailByStatus returns how many messages the outbox holds in each status
+func (m *sqlDBRepo) CountMailByStatus(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return countMailByStatus(ctx, m.DB)
+}
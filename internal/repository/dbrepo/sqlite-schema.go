@@ -0,0 +1,295 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gobuffalo/fizz"
+	"github.com/gobuffalo/fizz/translators"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sqliteTranslator wraps the fizz SQLite translator so the same migrations
+// used for MySQL and Postgres can build a local SQLite schema
+type sqliteTranslator struct {
+	*translators.SQLite
+}
+
+// CreateTable stores date columns as DATETIME so the driver scans them back into time.Time
+func (t sqliteTranslator) CreateTable(table fizz.Table) (string, error) {
+	table.Columns = sqliteColumns(table.Columns)
+	return t.SQLite.CreateTable(table)
+}
+
+// AddColumn stores date columns as DATETIME so the driver scans them back into time.Time
+func (t sqliteTranslator) AddColumn(table fizz.Table) (string, error) {
+	table.Columns = sqliteColumns(table.Columns)
+	return t.SQLite.AddColumn(table)
+}
+
+// ChangeColumn stores date columns as DATETIME so the driver scans them back into time.Time
+func (t sqliteTranslator) ChangeColumn(table fizz.Table) (string, error) {
+	table.Columns = sqliteColumns(table.Columns)
+	return t.SQLite.ChangeColumn(table)
+}
+
+// AddForeignKey rebuilds the table with the foreign key, since SQLite only takes them in create table
+func (t sqliteTranslator) AddForeignKey(table fizz.Table) (string, error) {
+	info, err := t.Schema.TableInfo(table.Name)
+	if err != nil {
+		return "", err
+	}
+	info.ForeignKeys = append(info.ForeignKeys, table.ForeignKeys...)
+	return t.rebuildTable(info)
+}
+
+// DropForeignKey rebuilds the table without the foreign key
+func (t sqliteTranslator) DropForeignKey(table fizz.Table) (string, error) {
+	info, err := t.Schema.TableInfo(table.Name)
+	if err != nil {
+		return "", err
+	}
+	var kept []fizz.ForeignKey
+	for _, fk := range info.ForeignKeys {
+		if fk.Name != table.ForeignKeys[0].Name {
+			kept = append(kept, fk)
+		}
+	}
+	if len(kept) == len(info.ForeignKeys) {
+		return "", fmt.Errorf("could not find foreign key %s on table %s", table.ForeignKeys[0].Name, table.Name)
+	}
+	info.ForeignKeys = kept
+	return t.rebuildTable(info)
+}
+
+// rebuildTable recreates a table as info now describes it, copying its rows over and adding its
+// indexes back. The rows are copied as they are, so foreign keys must be off meanwhile, or dropping
+// the old table would cascade to the rows referencing it
+func (t sqliteTranslator) rebuildTable(info *fizz.Table) (string, error) {
+	tmp := *info
+	tmp.Name = "_" + info.Name + "_tmp"
+	tmp.Indexes = nil
+	create, err := t.SQLite.CreateTable(tmp)
+	if err != nil {
+		return "", err
+	}
+	t.Schema.Delete(tmp.Name)
+
+	columns := make([]string, len(info.Columns))
+	for i, c := range info.Columns {
+		columns[i] = fmt.Sprintf("\"%s\"", c.Name)
+	}
+	list := strings.Join(columns, ", ")
+
+	statements := []string{
+		create,
+		fmt.Sprintf("INSERT INTO \"%s\" (%s) SELECT %s FROM \"%s\";", tmp.Name, list, list, info.Name),
+		fmt.Sprintf("DROP TABLE \"%s\";", info.Name),
+		fmt.Sprintf("ALTER TABLE \"%s\" RENAME TO \"%s\";", tmp.Name, info.Name),
+	}
+	// AddIndex records the indexes on the table again
+	indexes := info.Indexes
+	info.Indexes = nil
+	for _, i := range indexes {
+		s, err := t.SQLite.AddIndex(fizz.Table{Name: info.Name, Indexes: []fizz.Index{i}})
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, s)
+	}
+	return strings.Join(statements, "\n"), nil
+}
+
+// sqliteColumns adjusts column types and defaults for SQLite. Besides the date columns, bool
+// defaults become 1 and 0, since the translator quotes them and SQLite would keep the text 'true'
+func sqliteColumns(columns []fizz.Column) []fizz.Column {
+	for i, c := range columns {
+		if strings.EqualFold(c.ColType, "date") {
+			columns[i].ColType = "datetime"
+		}
+		if strings.EqualFold(c.ColType, "bool") || strings.EqualFold(c.ColType, "boolean") {
+			switch c.Options["default"] {
+			case true:
+				columns[i].Options["default"] = 1
+			case false:
+				columns[i].Options["default"] = 0
+			}
+		}
+	}
+	return columns
+}
+
+// MigrateSQLite applies every *.up.fizz migration in dir that has not been applied yet. Foreign keys
+// are off while it does, since tables are rebuilt to change them, and checked once it's done
+func MigrateSQLite(db *sql.DB, dir string) error {
+	ctx := context.Background()
+
+	// the pragma holds for a connection, and is ignored inside a transaction
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err = conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+
+	err = migrateSQLite(ctx, conn, dir)
+	if !foreignKeys {
+		return err
+	}
+
+	if _, restoreErr := conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`); restoreErr != nil && err == nil {
+		err = restoreErr
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fk int
+		if err = rows.Scan(&table, &rowid, &parent, &fk); err != nil {
+			return err
+		}
+		return fmt.Errorf("row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
+
+// migrateSQLite applies the migrations on conn, each in its own transaction
+func migrateSQLite(ctx context.Context, conn *sql.Conn, dir string) error {
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_migration (version text primary key)`)
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.fizz"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations found in %s", dir)
+	}
+	sort.Strings(files)
+
+	// the translator keeps track of the schema as migrations run, which it
+	// needs to rebuild tables for change_column, so use one for all of them
+	translator := sqliteTranslator{translators.NewSQLite("")}
+
+	for _, file := range files {
+		version := strings.SplitN(filepath.Base(file), "_", 2)[0]
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		statements, err := fizz.AString(string(content), translator)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+
+		var applied int
+		err = conn.QueryRowContext(ctx, `select count(*) from schema_migration where version = ?`, version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(statements) != "" {
+			if _, err = tx.Exec(statements); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("%s: %w", filepath.Base(file), err)
+			}
+		}
+		if _, err = tx.Exec(`insert into schema_migration (version) values (?)`, version); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SeedSQLite adds sample rooms, the restriction types and an admin user to an empty database
+func SeedSQLite(conn *sql.DB) error {
+	var numRooms int
+	err := conn.QueryRow(`select count(id) from rooms`).Scan(&numRooms)
+	if err != nil {
+		return err
+	}
+	if numRooms > 0 {
+		return nil
+	}
+
+	password, err := bcrypt.GenerateFromPassword([]byte("password"), 12)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	description := "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember."
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	seeds := []struct {
+		statement string
+		args      []interface{}
+	}{
+		{`insert into rooms (id, room_name, slug, description, amenities, base_rate, weekend_rate, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, "General's Quarters", "generals-quarters", description, "Ocean view\nQueen size bed\nBreakfast included", 9900, 12900, now, now}},
+		{`insert into rooms (id, room_name, slug, description, amenities, base_rate, weekend_rate, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{2, "Major's Suite", "majors-suite", description, "Ocean view\nSitting room\nBreakfast included", 14900, 17900, now, now}},
+		{`insert into room_photos (room_id, file_name, position, created_at, updated_at) values (?, ?, ?, ?, ?)`,
+			[]interface{}{1, "generals-quarters.png", 1, now, now}},
+		{`insert into room_photos (room_id, file_name, position, created_at, updated_at) values (?, ?, ?, ?, ?)`,
+			[]interface{}{2, "marjors-suite.png", 1, now, now}},
+		{`insert into restrictions (id, restriction_name, created_at, updated_at) values (?, ?, ?, ?)`,
+			[]interface{}{1, "Reservation", now, now}},
+		{`insert into restrictions (id, restriction_name, created_at, updated_at) values (?, ?, ?, ?)`,
+			[]interface{}{2, "Owner Block", now, now}},
+		{`insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{"Admin", "User", "admin@admin.com", string(password), 3, now, now}},
+	}
+
+	for _, s := range seeds {
+		if _, err = tx.Exec(s.statement, s.args...); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,1357 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Seician/bookings/internal/config"
+	"github.com/Seician/bookings/internal/driver"
+	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var pathToMigrations = "./../../../migrations"
+
+// newSQLiteTestRepo returns a repository backed by a fresh, migrated and seeded in-memory database
+func newSQLiteTestRepo(t *testing.T) repository.DatabaseRepo {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.SQL.Close() })
+
+	if err = MigrateSQLite(db.SQL, pathToMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if err = SeedSQLite(db.SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLiteRepo(db.SQL, &config.AppConfig{})
+}
+
+func TestSQLDBRepo_rebind(t *testing.T) {
+	query := `select id from rooms where id = ? and active = ?`
+
+	postgres := &sqlDBRepo{bind: dollarN}
+	if got := postgres.rebind(query); got != `select id from rooms where id = $1 and active = $2` {
+		t.Errorf("got %q for postgres", got)
+	}
+	mysql := &sqlDBRepo{bind: questionMark}
+	if got := mysql.rebind(query); got != query {
+		t.Errorf("got %q for mysql", got)
+	}
+}
+
+func TestMigrateSQLite_Twice(t *testing.T) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	if err = MigrateSQLite(db.SQL, pathToMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if err = MigrateSQLite(db.SQL, pathToMigrations); err != nil {
+		t.Error("running migrations a second time failed:", err)
+	}
+}
+
+func TestSQLiteRepo_Reservations(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	end, _ := time.Parse("2006-01-02", "2050-01-03")
+
+	room, err := repo.GetRoomById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.RoomName != "General's Quarters" {
+		t.Errorf("got room %q, wanted the seeded General's Quarters", room.RoomName)
+	}
+
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Errorf("got %d available rooms, wanted 2", len(rooms))
+	}
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: start,
+		EndDate:   end,
+		RoomId:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomId:        1,
+		ReservationId: int(id),
+		RestrictionId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, start.AddDate(0, 0, 1), end.AddDate(0, 0, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("room 1 shows as available for overlapping dates")
+	}
+
+	// departure day is free for the next guest
+	available, err = repo.SearchAvailabilityByDatesByRoomId(ctx, end, end.AddDate(0, 0, 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("room 1 shows as unavailable starting on the departure day")
+	}
+
+	rooms, err = repo.SearchAvailabilityForAllRooms(ctx, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("got %v, wanted only room 2 to be available", rooms)
+	}
+}
+
+func TestSQLiteRepo_CreateReservation(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	end, _ := time.Parse("2006-01-02", "2050-01-05")
+
+	reservation := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: start,
+		EndDate:   end,
+		RoomId:    1,
+	}
+
+	id, err := repo.CreateReservation(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 {
+		t.Error("got no id for the new reservation")
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, start, end, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("CreateReservation did not add a room restriction")
+	}
+
+	// a second guest asking for overlapping dates gets ErrRoomUnavailable
+	reservation.StartDate = start.AddDate(0, 0, 2)
+	reservation.EndDate = end.AddDate(0, 0, 2)
+	_, err = repo.CreateReservation(ctx, reservation)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("got %v for an overlapping booking, wanted ErrRoomUnavailable", err)
+	}
+
+	// but may book from the departure day on
+	reservation.StartDate = end
+	reservation.EndDate = end.AddDate(0, 0, 1)
+	_, err = repo.CreateReservation(ctx, reservation)
+	if err != nil {
+		t.Errorf("could not book from the departure day: %v", err)
+	}
+}
+
+func TestSQLiteRepo_UpdateReservation(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	end, _ := time.Parse("2006-01-02", "2050-01-05")
+
+	first, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: end, RoomId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", StartDate: start, EndDate: end, RoomId: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Room.RoomName != "General's Quarters" {
+		t.Errorf("got room %q, wanted General's Quarters", res.Room.RoomName)
+	}
+
+	// moving within its own dates does not clash with itself
+	res.LastName = "Smythe"
+	res.EndDate = end.AddDate(0, 0, 2)
+	err = repo.UpdateReservation(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, end, end.AddDate(0, 0, 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("the room restriction did not move with the new departure date")
+	}
+
+	// room 2 is taken by the second reservation
+	res.RoomId = 2
+	err = repo.UpdateReservation(ctx, res)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("got %v moving onto a booked room, wanted ErrRoomUnavailable", err)
+	}
+
+	saved, err := repo.GetReservationByID(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.LastName != "Smythe" || saved.RoomId != 1 {
+		t.Errorf("got %s in room %d, wanted the first update only", saved.LastName, saved.RoomId)
+	}
+
+	err = repo.UpdateProcessedForReservation(ctx, first, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ = repo.GetReservationByID(ctx, first)
+	if saved.Processed != 1 {
+		t.Error("reservation was not marked as processed")
+	}
+
+	_, err = repo.GetReservationByID(ctx, 1000)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for a missing reservation, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_CancelReservation(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	end, _ := time.Parse("2006-01-02", "2050-01-05")
+
+	reservation := models.Reservation{
+		FirstName:   "John",
+		LastName:    "Smith",
+		Email:       "john@smith.com",
+		StartDate:   start,
+		EndDate:     end,
+		RoomId:      1,
+		CancelToken: "secret",
+	}
+
+	id, err := repo.CreateReservation(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByToken(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != id || res.Status != models.ReservationConfirmed {
+		t.Errorf("got reservation %d with status %q, wanted %d confirmed", res.ID, res.Status, id)
+	}
+
+	_, err = repo.GetReservationByToken(ctx, "")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for an empty token, wanted sql.ErrNoRows", err)
+	}
+
+	err = repo.CancelReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, start, end, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("cancelling did not release the room")
+	}
+
+	err = repo.CancelReservation(ctx, id)
+	if !errors.Is(err, repository.ErrNotCancellable) {
+		t.Errorf("got %v cancelling twice, wanted ErrNotCancellable", err)
+	}
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationConfirmed)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("got %v reopening a cancelled reservation, wanted ErrInvalidStatusTransition", err)
+	}
+
+	list, total, err := repo.ListReservations(ctx, models.ReservationFilter{Status: models.ReservationCancelled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || list[0].Status != models.ReservationCancelled {
+		t.Errorf("got %d cancelled reservations, wanted 1", total)
+	}
+
+	// a checked in guest can't cancel any more
+	reservation.CancelToken = "other"
+	id, err = repo.CreateReservation(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationCheckedIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.CancelReservation(ctx, id)
+	if !errors.Is(err, repository.ErrNotCancellable) {
+		t.Errorf("got %v cancelling a checked in reservation, wanted ErrNotCancellable", err)
+	}
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationCancelled)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("got %v cancelling a checked in reservation from the admin, wanted ErrInvalidStatusTransition", err)
+	}
+
+	// a stay only moves forward
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationPending)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("got %v moving a checked in reservation back to pending, wanted ErrInvalidStatusTransition", err)
+	}
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationCheckedOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationCheckedIn)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("got %v checking a departed guest back in, wanted ErrInvalidStatusTransition", err)
+	}
+	err = repo.UpdateReservationStatus(ctx, 9999, models.ReservationCheckedIn)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for a missing reservation, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_GetRestrictionsForRoomByDate(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-30")
+	end, _ := time.Parse("2006-01-02", "2050-02-02")
+
+	_, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: end, RoomId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	january, _ := time.Parse("2006-01-02", "2050-01-01")
+	february := january.AddDate(0, 1, 0)
+	march := february.AddDate(0, 1, 0)
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, 1, january, february)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Fatalf("got %d restrictions in January, wanted 1", len(restrictions))
+	}
+	if restrictions[0].ReservationId == 0 || restrictions[0].Restriction.RestrictionName != "Reservation" {
+		t.Errorf("got restriction %+v, wanted the reservation", restrictions[0])
+	}
+
+	restrictions, _ = repo.GetRestrictionsForRoomByDate(ctx, 1, february, march)
+	if len(restrictions) != 1 {
+		t.Errorf("got %d restrictions in February, wanted the stay running into it", len(restrictions))
+	}
+
+	restrictions, _ = repo.GetRestrictionsForRoomByDate(ctx, 2, january, february)
+	if len(restrictions) != 0 {
+		t.Errorf("got %d restrictions for room 2, wanted none", len(restrictions))
+	}
+}
+
+func TestSQLiteRepo_Blocks(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	restrictions, err := repo.AllRestrictions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 5 {
+		t.Errorf("got %d kinds of restriction, wanted 5", len(restrictions))
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+	end := start.AddDate(0, 0, 3)
+
+	block := models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomId:        2,
+		RestrictionId: models.RestrictionMaintenance,
+	}
+	err = repo.CreateBlock(ctx, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.StartDate = start.AddDate(0, 0, 2)
+	block.EndDate = end.AddDate(0, 0, 2)
+	err = repo.CreateBlock(ctx, block)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("got %v blocking blocked days, wanted ErrRoomUnavailable", err)
+	}
+
+	// both searches leave the blocked room out
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("blocked room shows as available")
+	}
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != 1 {
+		t.Errorf("got %v available, wanted room 1 only", rooms)
+	}
+	_, err = repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: end, RoomId: 2,
+	})
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("got %v booking a blocked room, wanted ErrRoomUnavailable", err)
+	}
+
+	// reservations are not blocks
+	_, err = repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: end, RoomId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := repo.ListBlocks(ctx, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Restriction.RestrictionName != "Maintenance" || blocks[0].Room.RoomName != "Major's Suite" {
+		t.Fatalf("got blocks %+v, wanted the maintenance of room 2", blocks)
+	}
+
+	roomRestrictions, _ := repo.GetRestrictionsForRoomByDate(ctx, 1, start, end)
+	err = repo.DeleteBlockByID(ctx, roomRestrictions[0].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting a reservation's restriction, wanted sql.ErrNoRows", err)
+	}
+
+	err = repo.DeleteBlockByID(ctx, blocks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomId(ctx, start, end, 2)
+	if !available {
+		t.Error("room is still blocked after deleting the block")
+	}
+}
+
+func TestSQLiteRepo_Rooms(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Description: "Cosy", Capacity: 3, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := repo.GetRoomById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.RoomName != "Colonel's Cabin" || room.Capacity != 3 || !room.Active {
+		t.Errorf("got %+v, wanted the new room", room)
+	}
+
+	rooms, _ := repo.AllRooms(ctx)
+	if len(rooms) != 3 {
+		t.Errorf("got %d rooms, wanted 3", len(rooms))
+	}
+
+	// an inactive room is not offered
+	room.Active = false
+	err = repo.UpdateRoom(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	available, err := repo.SearchAvailabilityForAllRooms(ctx, start, start.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range available {
+		if r.ID == id {
+			t.Error("inactive room shows up in the availability search")
+		}
+	}
+	free, err := repo.SearchAvailabilityByDatesByRoomId(ctx, start, start.AddDate(0, 0, 2), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free {
+		t.Error("inactive room is available by its id")
+	}
+
+	// nor can it be booked by its id
+	_, err = repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomId: id,
+	})
+	if !errors.Is(err, repository.ErrRoomInactive) {
+		t.Errorf("got %v booking an inactive room, wanted ErrRoomInactive", err)
+	}
+
+	// a booked room can't be deleted
+	resID, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DeleteRoom(ctx, 1)
+	if !errors.Is(err, repository.ErrRoomHasReservations) {
+		t.Errorf("got %v deleting a booked room, wanted ErrRoomHasReservations", err)
+	}
+
+	// not even once the reservation is cancelled, since it keeps its room
+	err = repo.CancelReservation(ctx, resID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DeleteRoom(ctx, 1)
+	if !errors.Is(err, repository.ErrRoomHasReservations) {
+		t.Errorf("got %v deleting a room with a cancelled reservation, wanted ErrRoomHasReservations", err)
+	}
+
+	err = repo.DeleteRoom(ctx, id)
+	if err != nil {
+		t.Errorf("could not delete a room that was never booked: %v", err)
+	}
+
+	err = repo.DeleteRoom(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting a missing room, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_GetRoomBySlug(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	room, err := repo.GetRoomBySlug(ctx, "majors-suite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.ID != 2 || len(room.Photos) != 1 || room.Photos[0].FileName != "marjors-suite.png" {
+		t.Errorf("got %+v, wanted the seeded Major's Suite with its photo", room)
+	}
+	if len(room.Amenities) != 3 {
+		t.Errorf("got amenities %q, wanted 3", room.Amenities)
+	}
+
+	room.Amenities = []string{"Sea view"}
+	room.Photos = []models.RoomPhoto{{FileName: "outside.png"}, {FileName: "tray.png", Caption: "Breakfast"}}
+	err = repo.UpdateRoom(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err = repo.GetRoomById(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(room.Amenities) != 1 || len(room.Photos) != 2 || room.Photos[1].Caption != "Breakfast" || room.Photos[1].Position != 2 {
+		t.Errorf("got %+v, wanted the new amenities and photos", room)
+	}
+
+	_, err = repo.GetRoomBySlug(ctx, "colonels-cabin")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for a missing room, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_RoomRates(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	room, err := repo.GetRoomById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.BaseRate != 9900 || room.WeekendRate != 12900 {
+		t.Errorf("got rates %d and %d for the seeded room, wanted 9900 and 12900", room.BaseRate, room.WeekendRate)
+	}
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	seasons := []models.RoomRate{
+		{RoomId: 1, Name: "Summer", StartDate: day("2050-07-01"), EndDate: day("2050-08-31"), NightlyRate: 15000},
+		{RoomId: 1, Name: "Festival", StartDate: day("2050-07-10"), EndDate: day("2050-07-12"), NightlyRate: 25000, WeekendRate: 30000},
+		{RoomId: 2, Name: "Summer", StartDate: day("2050-07-01"), EndDate: day("2050-08-31"), NightlyRate: 20000},
+	}
+	for _, s := range seasons {
+		if err = repo.InsertRoomRate(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rates, err := repo.GetRoomRates(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[0].Name != "Summer" || rates[1].WeekendRate != 30000 {
+		t.Errorf("got %+v, wanted the two seasons of room 1 in date order", rates)
+	}
+
+	// the last night of a season counts, the departure day does not
+	var tests = []struct {
+		start, end string
+		expected   int
+	}{
+		{"2050-06-28", "2050-07-01", 0},
+		{"2050-06-28", "2050-07-02", 1},
+		{"2050-07-12", "2050-07-14", 2},
+		{"2050-08-31", "2050-09-02", 1},
+		{"2050-09-01", "2050-09-02", 0},
+	}
+	for _, e := range tests {
+		rates, err = repo.GetRoomRatesByDate(ctx, 1, day(e.start), day(e.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rates) != e.expected {
+			t.Errorf("from %s to %s: got %d seasons, wanted %d", e.start, e.end, len(rates), e.expected)
+		}
+	}
+
+	rates, _ = repo.GetRoomRates(ctx, 1)
+	err = repo.DeleteRoomRate(ctx, 2, rates[0].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting a season of another room, wanted sql.ErrNoRows", err)
+	}
+	if err = repo.DeleteRoomRate(ctx, 1, rates[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	rates, _ = repo.GetRoomRates(ctx, 1)
+	if len(rates) != 1 || rates[0].Name != "Festival" {
+		t.Errorf("got %+v after deleting Summer, wanted only Festival", rates)
+	}
+}
+
+func TestSQLiteRepo_RoomCalendars(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	err := repo.InsertRoomCalendar(ctx, models.RoomCalendar{RoomId: 2, Name: "Other Channel", URL: "https://example.com/room.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := repo.GetRoomCalendars(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 1 || calendars[0].Name != "Other Channel" || !calendars[0].LastSyncedAt.IsZero() {
+		t.Fatalf("got %+v, wanted the new calendar of room 2, never synced", calendars)
+	}
+	cal := calendars[0]
+
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+	block := func(uid string, from, nights int) models.RoomRestriction {
+		return models.RoomRestriction{
+			ExternalUID: uid,
+			StartDate:   start.AddDate(0, 0, from),
+			EndDate:     start.AddDate(0, 0, from+nights),
+		}
+	}
+
+	result, err := repo.SyncRoomCalendar(ctx, cal, []models.RoomRestriction{block("a", 0, 3), block("b", 10, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (models.CalendarSync{Added: 2}) {
+		t.Errorf("got %+v on the first sync, wanted 2 added", result)
+	}
+
+	available, _ := repo.SearchAvailabilityByDatesByRoomId(ctx, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), 2)
+	if available {
+		t.Error("room booked on another channel shows as available")
+	}
+	blocks, _ := repo.ListBlocks(ctx, start)
+	if len(blocks) != 2 || blocks[0].RestrictionId != models.RestrictionExternal || blocks[0].RoomCalendarId != cal.ID {
+		t.Fatalf("got blocks %+v, wanted the two external bookings", blocks)
+	}
+	err = repo.DeleteBlockByID(ctx, blocks[0].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting an imported block, wanted sql.ErrNoRows", err)
+	}
+
+	// a moves, b is cancelled, c is new and an unchanged feed changes nothing
+	feed := []models.RoomRestriction{block("a", 1, 3), block("c", 20, 1)}
+	result, err = repo.SyncRoomCalendar(ctx, cal, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (models.CalendarSync{Added: 1, Updated: 1, Removed: 1}) {
+		t.Errorf("got %+v on the second sync, wanted 1 added, updated and removed", result)
+	}
+	result, _ = repo.SyncRoomCalendar(ctx, cal, feed)
+	if result != (models.CalendarSync{}) {
+		t.Errorf("got %+v syncing the same feed again, wanted no changes", result)
+	}
+
+	available, _ = repo.SearchAvailabilityByDatesByRoomId(ctx, start, start.AddDate(0, 0, 1), 2)
+	if !available {
+		t.Error("room is still blocked on the day a moved booking no longer covers")
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomId(ctx, start.AddDate(0, 0, 10), start.AddDate(0, 0, 12), 2)
+	if !available {
+		t.Error("room is still blocked for a booking cancelled on the other channel")
+	}
+
+	if err = repo.UpdateRoomCalendarStatus(ctx, cal.ID, "feed returned 500 Internal Server Error"); err != nil {
+		t.Fatal(err)
+	}
+	cal, _ = repo.GetRoomCalendarByID(ctx, cal.ID)
+	if cal.LastError == "" || !cal.LastSyncedAt.IsZero() {
+		t.Errorf("got %+v after a failed sync, wanted the error and no sync time", cal)
+	}
+	if err = repo.UpdateRoomCalendarStatus(ctx, cal.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cal, _ = repo.GetRoomCalendarByID(ctx, cal.ID)
+	if cal.LastError != "" || cal.LastSyncedAt.IsZero() {
+		t.Errorf("got %+v after a sync that worked, wanted a sync time and no error", cal)
+	}
+
+	all, _ := repo.AllRoomCalendars(ctx)
+	if len(all) != 1 {
+		t.Errorf("got %d calendars in all, wanted 1", len(all))
+	}
+
+	err = repo.DeleteRoomCalendar(ctx, 1, cal.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting the calendar of another room, wanted sql.ErrNoRows", err)
+	}
+	if err = repo.DeleteRoomCalendar(ctx, 2, cal.ID); err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ = repo.ListBlocks(ctx, start)
+	if len(blocks) != 0 {
+		t.Errorf("got %+v after deleting the calendar, wanted its blocks gone", blocks)
+	}
+	_, err = repo.GetRoomCalendarByID(ctx, cal.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v reading a deleted calendar, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_MailOutbox(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	id, err := repo.InsertMail(ctx, models.MailData{
+		To:           "john@smith.com",
+		From:         "bookings@example.com",
+		Subject:      "Reservation confirmation",
+		Content:      "<p>See you soon</p>",
+		PlainContent: "See you soon",
+		Attachments:  []models.MailAttachment{{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := repo.ClaimMail(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != id || claimed[0].Status != models.MailSending {
+		t.Fatalf("got %+v, wanted the new message, sending", claimed)
+	}
+	if len(claimed[0].Mail.Attachments) != 1 || string(claimed[0].Mail.Attachments[0].Data) != "%PDF" {
+		t.Errorf("got attachments %+v, wanted the invoice", claimed[0].Mail.Attachments)
+	}
+
+	// a message being sent isn't handed out twice
+	claimed, _ = repo.ClaimMail(ctx, 10)
+	if len(claimed) != 0 {
+		t.Errorf("claimed %d messages that were already being sent", len(claimed))
+	}
+
+	err = repo.RecordMailAttempt(ctx, id, models.MailPending, "connection refused", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = repo.ClaimMail(ctx, 10)
+	if len(claimed) != 0 {
+		t.Error("claimed a message before its next attempt was due")
+	}
+
+	err = repo.ResendMail(ctx, id)
+	if err != repository.ErrMailQueued {
+		t.Errorf("got %v, wanted repository.ErrMailQueued for a pending message", err)
+	}
+
+	err = repo.RecordMailAttempt(ctx, id, models.MailFailed, "connection refused", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := repo.GetMailByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != models.MailFailed || msg.Attempts != 2 || msg.LastError != "connection refused" || len(msg.Deliveries) != 2 {
+		t.Errorf("got %+v, wanted a message failed after 2 attempts", msg)
+	}
+
+	failed, err := repo.ListMail(ctx, models.MailFailed, 10)
+	if err != nil || len(failed) != 1 {
+		t.Errorf("got %v and %v, wanted the failed message", failed, err)
+	}
+	counts, err := repo.CountMailByStatus(ctx)
+	if err != nil || counts[models.MailFailed] != 1 || counts[models.MailPending] != 0 {
+		t.Errorf("got counts %v and %v, wanted 1 failed", counts, err)
+	}
+
+	if err = repo.ResendMail(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = repo.ClaimMail(ctx, 10)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].AttemptsBeforeResend != 2 {
+		t.Fatalf("got %+v, wanted the resent message with its 2 attempts set aside", claimed)
+	}
+	if err = repo.RecordMailAttempt(ctx, id, models.MailSent, "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ = repo.GetMailByID(ctx, id)
+	if msg.Status != models.MailSent || msg.SentAt.IsZero() || len(msg.Deliveries) != 3 {
+		t.Fatalf("got %+v, wanted a sent message with 3 deliveries", msg)
+	}
+	// deliveries are numbered across resends
+	for i, d := range msg.Deliveries {
+		if d.Attempt != i+1 {
+			t.Errorf("delivery %d is numbered attempt %d", i+1, d.Attempt)
+		}
+	}
+
+	_, err = repo.GetMailByID(ctx, id+1)
+	if err != sql.ErrNoRows {
+		t.Errorf("got %v, wanted sql.ErrNoRows for a missing message", err)
+	}
+	if err = repo.ResendMail(ctx, id+1); err != sql.ErrNoRows {
+		t.Errorf("got %v, wanted sql.ErrNoRows resending a missing message", err)
+	}
+}
+
+func TestSQLiteRepo_TotalPrice(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	id, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  start,
+		EndDate:    start.AddDate(0, 0, 2),
+		RoomId:     1,
+		TotalPrice: 22800,
+		Nights: []models.ReservationNight{
+			{Date: start, Rate: 12900, Weekend: true},
+			{Date: start.AddDate(0, 0, 1), Rate: 9900, Season: "Winter"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalPrice != 22800 {
+		t.Errorf("got total price %d, wanted 22800", res.TotalPrice)
+	}
+	if len(res.Nights) != 2 || !res.Nights[0].Weekend || res.Nights[0].Rate != 12900 ||
+		res.Nights[1].Season != "Winter" || !res.Nights[1].Date.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("got nights %+v, wanted the two nights booked", res.Nights)
+	}
+
+	res.TotalPrice = 9900
+	res.EndDate = start.AddDate(0, 0, 1)
+	res.Nights = []models.ReservationNight{{Date: start, Rate: 9900}}
+	if err = repo.UpdateReservation(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Nights) != 1 || res.Nights[0].Rate != 9900 {
+		t.Errorf("got nights %+v, wanted the one night left", res.Nights)
+	}
+
+	list, _, err := repo.ListReservations(ctx, models.ReservationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].TotalPrice != 9900 {
+		t.Errorf("got %+v, wanted one reservation with a total price of 9900", list)
+	}
+}
+
+func TestSQLiteRepo_Payments(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	id, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName:     "John",
+		LastName:      "Smith",
+		Email:         "john@smith.com",
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 1),
+		RoomId:        1,
+		TotalPrice:    12900,
+		Deposit:       2580,
+		PaymentId:     "fake_1",
+		PaymentStatus: "captured",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.UpdatePaymentStatus(ctx, "fake_1", "refunded"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deposit != 2580 || res.PaymentId != "fake_1" || res.PaymentStatus != "refunded" {
+		t.Errorf("got a %q deposit of %d for payment %q, wanted a refunded deposit of 2580 for fake_1",
+			res.PaymentStatus, res.Deposit, res.PaymentId)
+	}
+
+	err = repo.UpdatePaymentStatus(ctx, "fake_2", "captured")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown payment: got %v, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_InvoiceForReservation(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	var ids []int
+	for i := 0; i < 2; i++ {
+		id, err := repo.CreateReservation(ctx, models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: start.AddDate(0, 0, 2*i),
+			EndDate:   start.AddDate(0, 0, 2*i+1),
+			RoomId:    1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	draft := func(id, total int) models.Invoice {
+		return models.Invoice{
+			ReservationId: id,
+			Lines: []models.InvoiceLine{
+				{Label: "Sat, Jan 1 2050", Detail: "Weekend", Amount: total + 1000},
+				{Label: "Discount", Detail: "Promo code SUMMER10", Amount: -1000},
+			},
+			Total:      total,
+			TaxPercent: 7.5,
+			Tax:        750,
+			Paid:       2000,
+		}
+	}
+
+	// numbers go in the order invoices are issued, not by reservation
+	second, err := repo.InvoiceForReservation(ctx, draft(ids[1], 10750))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := repo.InvoiceForReservation(ctx, draft(ids[0], 10750))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Number != 1 || first.Number != 2 {
+		t.Errorf("got invoice numbers %d and %d, wanted 1 and 2", second.Number, first.Number)
+	}
+
+	// an issued invoice keeps its lines and totals, whatever the reservation costs now
+	again, err := repo.InvoiceForReservation(ctx, draft(ids[1], 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Number != 1 || again.ID != second.ID {
+		t.Errorf("asking again issued invoice %d, wanted the existing invoice 1", again.Number)
+	}
+	if !reflect.DeepEqual(again.Lines, draft(ids[1], 10750).Lines) || again.Total != 10750 ||
+		again.TaxPercent != 7.5 || again.Tax != 750 || again.Paid != 2000 {
+		t.Errorf("got invoice %+v, wanted it as it was issued", again)
+	}
+
+	// an invoice issued before invoices kept their lines is given them when it's read again
+	_, err = repo.(*sqlDBRepo).DB.ExecContext(ctx, `delete from invoice_lines where invoice_id = ?`, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := repo.InvoiceForReservation(ctx, draft(ids[0], 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Number != 2 || old.Total != 5000 || len(old.Lines) != 2 {
+		t.Errorf("got invoice %+v, wanted number 2 with the lines of the draft", old)
+	}
+
+	_, err = repo.InvoiceForReservation(ctx, draft(999, 10750))
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown reservation: got %v, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_ForeignKeys(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+	db := repo.(*sqlDBRepo).DB
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	// a reservation with an invoice can't be deleted, and the invoice takes its lines along
+	id, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: start, EndDate: start.AddDate(0, 0, 1), RoomId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := repo.InvoiceForReservation(ctx, models.Invoice{
+		ReservationId: id,
+		Lines:         []models.InvoiceLine{{Label: "Sat, Jan 1 2050", Amount: 12900}},
+		Total:         12900,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ExecContext(ctx, `delete from reservations where id = ?`, id); err == nil {
+		t.Error("deleted a reservation that has an invoice")
+	}
+	if _, err = db.ExecContext(ctx, `delete from invoices where id = ?`, invoice.ID); err != nil {
+		t.Fatal(err)
+	}
+	var lines int
+	if err = db.QueryRowContext(ctx, `select count(id) from invoice_lines where invoice_id = ?`, invoice.ID).Scan(&lines); err != nil {
+		t.Fatal(err)
+	}
+	if lines != 0 {
+		t.Errorf("deleting an invoice left %d of its lines", lines)
+	}
+
+	// a deleted room takes its rates, calendars and blocks along
+	roomID, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Description: "Cosy", Capacity: 3, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.InsertRoomRate(ctx, models.RoomRate{RoomId: roomID, Name: "Summer", StartDate: start, EndDate: start.AddDate(0, 1, 0), NightlyRate: 9900})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.InsertRoomCalendar(ctx, models.RoomCalendar{RoomId: roomID, Name: "Airbnb", URL: "https://example.com/cal.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.CreateBlock(ctx, models.RoomRestriction{StartDate: start, EndDate: start.AddDate(0, 0, 3), RoomId: roomID, RestrictionId: models.RestrictionMaintenance})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.DeleteRoom(ctx, roomID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"room_rates", "room_calendars", "room_restrictions"} {
+		var n int
+		if err = db.QueryRowContext(ctx, `select count(id) from `+table+` where room_id = ?`, roomID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("deleting a room left %d rows in %s", n, table)
+		}
+	}
+
+	// and nothing can point at a room that isn't there
+	_, err = db.ExecContext(ctx, `insert into room_photos (room_id, file_name, position, created_at, updated_at) values (?, ?, ?, ?, ?)`,
+		roomID, "gone.png", 1, time.Now(), time.Now())
+	if err == nil {
+		t.Error("added a photo of a missing room")
+	}
+}
+
+func TestSQLiteRepo_PromoCodes(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	id, err := repo.InsertPromoCode(ctx, models.PromoCode{
+		Code:           "summer10",
+		DiscountType:   models.DiscountPercent,
+		Amount:         10,
+		StartDate:      start,
+		EndDate:        start.AddDate(0, 1, 0),
+		MinNights:      1,
+		MaxRedemptions: 1,
+		RoomIds:        []int{2, 1},
+		Active:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := repo.GetPromoCodeByCode(ctx, "Summer10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.ID != id || code.Code != "SUMMER10" || len(code.RoomIds) != 2 || code.RoomIds[0] != 1 {
+		t.Errorf("got %+v, wanted SUMMER10 limited to rooms 1 and 2", code)
+	}
+
+	reservation := models.Reservation{
+		FirstName:   "John",
+		LastName:    "Smith",
+		Email:       "john@smith.com",
+		StartDate:   start,
+		EndDate:     start.AddDate(0, 0, 2),
+		RoomId:      1,
+		TotalPrice:  20520,
+		PromoCodeId: id,
+		Discount:    2280,
+	}
+	resId, err := repo.CreateReservation(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, resId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromoCode != "SUMMER10" || res.Discount != 2280 {
+		t.Errorf("got code %q and discount %d, wanted SUMMER10 and 2280", res.PromoCode, res.Discount)
+	}
+
+	// the code had one redemption, so the next booking fails and leaves nothing behind
+	reservation.RoomId = 2
+	_, err = repo.CreateReservation(ctx, reservation)
+	if !errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		t.Errorf("got %v redeeming a used up code, wanted ErrPromoCodeUsedUp", err)
+	}
+	available, _ := repo.SearchAvailabilityByDatesByRoomId(ctx, reservation.StartDate, reservation.EndDate, 2)
+	if !available {
+		t.Error("the failed booking still holds the room")
+	}
+
+	// cancelling gives the redemption back
+	code, _ = repo.GetPromoCodeByID(ctx, id)
+	if code.Redemptions != 1 {
+		t.Errorf("got %d redemptions, wanted 1", code.Redemptions)
+	}
+	if err = repo.CancelReservation(ctx, resId); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := repo.AllPromoCodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0].Redemptions != 0 {
+		t.Errorf("got %+v, wanted one code with no redemptions", codes)
+	}
+
+	if err = repo.DeletePromoCode(ctx, id); !errors.Is(err, repository.ErrPromoCodeRedeemed) {
+		t.Errorf("got %v deleting a redeemed code, wanted ErrPromoCodeRedeemed", err)
+	}
+
+	code.Code = "winter"
+	code.RoomIds = nil
+	if err = repo.UpdatePromoCode(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	code, _ = repo.GetPromoCodeByID(ctx, id)
+	if code.Code != "WINTER" || len(code.RoomIds) != 0 {
+		t.Errorf("got %+v after the update, wanted WINTER for any room", code)
+	}
+
+	other, err := repo.InsertPromoCode(ctx, models.PromoCode{Code: "SPRING", DiscountType: models.DiscountFixed, Amount: 500,
+		StartDate: start, EndDate: start, RoomIds: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeletePromoCode(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeletePromoCode(ctx, other); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting a missing code, wanted sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteRepo_ListReservations(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	guests := []struct {
+		first, last, email string
+		start              string
+		roomId             int
+	}{
+		{"John", "Smith", "john@smith.com", "2050-01-01", 1},
+		{"Jane", "Doe", "jane@doe.com", "2050-01-10", 2},
+		{"Jack", "Smithers", "jack@example.com", "2050-02-01", 1},
+	}
+	for _, g := range guests {
+		start, _ := time.Parse("2006-01-02", g.start)
+		_, err := repo.CreateReservation(ctx, models.Reservation{
+			FirstName: g.first,
+			LastName:  g.last,
+			Email:     g.email,
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 2),
+			RoomId:    g.roomId,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, _ := time.Parse("2006-01-02", "2050-01-05")
+	to, _ := time.Parse("2006-01-02", "2050-01-31")
+
+	var tests = []struct {
+		name          string
+		filter        models.ReservationFilter
+		expectedTotal int
+		expectedFirst string
+	}{
+		{"all", models.ReservationFilter{}, 3, "Smith"},
+		{"newest first", models.ReservationFilter{Descending: true}, 3, "Smithers"},
+		{"by name", models.ReservationFilter{Sort: "name"}, 3, "Doe"},
+		{"by room", models.ReservationFilter{RoomId: 2}, 1, "Doe"},
+		{"by dates", models.ReservationFilter{From: from, To: to}, 1, "Doe"},
+		{"by guest", models.ReservationFilter{Guest: "SMITH"}, 2, "Smith"},
+		{"by email", models.ReservationFilter{Guest: "example.com"}, 1, "Smithers"},
+		{"new", models.ReservationFilter{Status: "new"}, 3, "Smith"},
+		{"processed", models.ReservationFilter{Status: "processed"}, 0, ""},
+		{"second page", models.ReservationFilter{PerPage: 2, Page: 2}, 3, "Smithers"},
+		{"unknown sort column", models.ReservationFilter{Sort: "1; drop table rooms"}, 3, "Smith"},
+	}
+
+	for _, e := range tests {
+		reservations, total, err := repo.ListReservations(ctx, e.filter)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if total != e.expectedTotal {
+			t.Errorf("%s: got total %d, wanted %d", e.name, total, e.expectedTotal)
+		}
+		first := ""
+		if len(reservations) > 0 {
+			first = reservations[0].LastName
+			if reservations[0].Room.RoomName == "" {
+				t.Errorf("%s: room name was not joined", e.name)
+			}
+		}
+		if first != e.expectedFirst {
+			t.Errorf("%s: got %q first, wanted %q", e.name, first, e.expectedFirst)
+		}
+	}
+}
+
+func TestSQLiteRepo_CancelledContext(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	// a client that went away cancels the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetRoomById(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v for a cancelled request, wanted context.Canceled", err)
+	}
+}
+
+func TestSQLiteRepo_Authenticate(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	id, _, err := repo.Authenticate(ctx, "admin@admin.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "admin@admin.com" {
+		t.Errorf("got user %q, wanted admin@admin.com", u.Email)
+	}
+
+	_, _, err = repo.Authenticate(ctx, "admin@admin.com", "wrong")
+	if err == nil {
+		t.Error("authenticated with the wrong password")
+	}
+}
@@ -1,18 +1,22 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"github.com/Seician/bookings/internal/models"
+	"github.com/Seician/bookings/internal/repository"
 	"log"
+	"strings"
 	"time"
 )
 
-func (m *testDBRepo) AllUsers() bool {
+func (m *testDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
 //InsertReservation insert reservation into the database
-func (m *testDBRepo) InsertReservation(reservation models.Reservation) (int64, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, reservation models.Reservation) (int64, error) {
 	//if the room id is 2 then fail; otherwise, pass
 
 	if reservation.RoomId == 2 {
@@ -22,15 +26,34 @@ func (m *testDBRepo) InsertReservation(reservation models.Reservation) (int64, e
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(restriction models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, restriction models.RoomRestriction) error {
 	if restriction.RoomId == 1000 {
 		return errors.New("some error")
 	}
 	return nil
 }
 
+// CreateReservation inserts a reservation and its room restriction in one transaction
+func (m *testDBRepo) CreateReservation(ctx context.Context, reservation models.Reservation) (int, error) {
+	// room 2 fails the reservation insert, room 1000 the restriction insert,
+	// room 3 is already booked and room 97 deactivated
+	switch reservation.RoomId {
+	case 2, 1000:
+		return 0, errors.New("some error")
+	case 3:
+		return 0, repository.ErrRoomUnavailable
+	case 97:
+		return 0, repository.ErrRoomInactive
+	}
+	// LASTONE was redeemed by someone else in the meantime
+	if reservation.PromoCodeId == 2 {
+		return 0, repository.ErrPromoCodeUsedUp
+	}
+	return 1, nil
+}
+
 //SearchAvailabilityByDatesByRoomId returns true if availability exists for roomId, and false if no availability exists
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
 	// set up a test time
 	layout := "2006-01-02"
 	str := "2049-12-31"
@@ -60,7 +83,7 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roo
 }
 
 //SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	var rooms []models.Room
 
 	// if the start date is after 2049-12-31, then return empty slice,
@@ -88,32 +111,537 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	// otherwise, put an entry into the slice, indicating that some room is
 	// available for search dates
 	room := models.Room{
-		ID: 1,
+		ID:       1,
+		BaseRate: 10000,
 	}
 	rooms = append(rooms, room)
 
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRoomById(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 
-	if id > 2 {
+	if id == 99 {
+		return room, sql.ErrNoRows
+	}
+	// 3 and 1000 exist so the reservation tests reach CreateReservation, and 97 has been deactivated
+	if id > 2 && id != 3 && id != 97 && id != 1000 {
 		return room, errors.New("some error")
 	}
+	room = models.Room{ID: id, RoomName: "General's Quarters", Description: "A room fit for a general", Capacity: 2, Active: id != 97,
+		BaseRate: 10000, WeekendRate: 15000}
 	return room, nil
 }
 
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	var u models.User
 
 	return u, nil
 }
 
-func (m *testDBRepo) UpdateUser(id int) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	return 1, "", nil
 }
+
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	rooms := []models.Room{
+		{ID: 1, RoomName: "General's Quarters", Capacity: 2, Active: true},
+		{ID: 2, RoomName: "Major's Suite", Capacity: 4, Active: true},
+	}
+	return rooms, nil
+}
+
+func (m *testDBRepo) ListReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error) {
+	var reservations []models.Reservation
+
+	// searching for the guest "error" simulates a failing query
+	if filter.Guest == "error" {
+		return reservations, 0, errors.New("some error")
+	}
+
+	reservations = append(reservations, models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		RoomId:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	})
+	return reservations, len(reservations), nil
+}
+
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	var res models.Reservation
+
+	// 99 does not exist, anything above fails
+	if id == 99 {
+		return res, sql.ErrNoRows
+	}
+	if id > 99 {
+		return res, errors.New("some error")
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	res = models.Reservation{
+		ID:        id,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+		RoomId:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		Status:    models.ReservationConfirmed,
+	}
+	return res, nil
+}
+
+func (m *testDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	// room 3 is already booked, room 1000 fails
+	switch res.RoomId {
+	case 3:
+		return repository.ErrRoomUnavailable
+	case 1000:
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetReservationByToken(ctx context.Context, token string) (models.Reservation, error) {
+	switch token {
+	case "missing":
+		return models.Reservation{}, sql.ErrNoRows
+	case "error":
+		return models.Reservation{}, errors.New("some error")
+	}
+
+	res, err := m.GetReservationByID(ctx, 1)
+	res.CancelToken = token
+	// the "arrived" guest has checked in already
+	if token == "arrived" {
+		res.ID = 2
+		res.Status = models.ReservationCheckedIn
+	}
+	return res, err
+}
+
+func (m *testDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	// reservation 2 has checked in, the others are confirmed, and 100 and up fail
+	if id > 99 {
+		return errors.New("some error")
+	}
+	current := models.ReservationConfirmed
+	if id == 2 {
+		current = models.ReservationCheckedIn
+	}
+	if !models.CanChangeStatus(current, status) {
+		return repository.ErrInvalidStatusTransition
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdatePaymentStatus(ctx context.Context, paymentId, status string) error {
+	// payments the test repo doesn't know are missing, "fail" fails
+	if paymentId == "fail" {
+		return errors.New("some error")
+	}
+	if paymentId == "missing" {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) InvoiceForReservation(ctx context.Context, draft models.Invoice) (models.Invoice, error) {
+	// reservation 98 can be read but not invoiced
+	if draft.ReservationId == 98 || draft.ReservationId > 99 {
+		return models.Invoice{}, errors.New("some error")
+	}
+	draft.ID = 1
+	draft.Number = 42
+	draft.CreatedAt = time.Now()
+	return draft, nil
+}
+
+func (m *testDBRepo) CancelReservation(ctx context.Context, id int) error {
+	// reservation 2 has checked in, 100 and up fail
+	if id == 2 {
+		return repository.ErrNotCancellable
+	}
+	if id > 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+
+	// room 1 has a three night reservation from the 10th, room 2 is blocked on the 20th and
+	// booked on another channel on the 25th
+	switch roomId {
+	case 1:
+		restrictions = append(restrictions, models.RoomRestriction{
+			ID:            1,
+			StartDate:     start.AddDate(0, 0, 9),
+			EndDate:       start.AddDate(0, 0, 12),
+			RoomId:        1,
+			ReservationId: 1,
+			RestrictionId: models.RestrictionReservation,
+			Restriction:   models.Restriction{ID: models.RestrictionReservation, RestrictionName: "Reservation"},
+		})
+	case 2:
+		restrictions = append(restrictions, models.RoomRestriction{
+			ID:            2,
+			StartDate:     start.AddDate(0, 0, 19),
+			EndDate:       start.AddDate(0, 0, 20),
+			RoomId:        2,
+			RestrictionId: 2,
+			Restriction:   models.Restriction{ID: 2, RestrictionName: "Owner Block"},
+		}, models.RoomRestriction{
+			ID:             3,
+			StartDate:      start.AddDate(0, 0, 24),
+			EndDate:        start.AddDate(0, 0, 26),
+			RoomId:         2,
+			RestrictionId:  models.RestrictionExternal,
+			Restriction:    models.Restriction{ID: models.RestrictionExternal, RestrictionName: "External Booking"},
+			RoomCalendarId: 1,
+		})
+	}
+	return restrictions, nil
+}
+
+func (m *testDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	restrictions := []models.Restriction{
+		{ID: models.RestrictionReservation, RestrictionName: "Reservation"},
+		{ID: models.RestrictionOwnerBlock, RestrictionName: "Owner Block"},
+		{ID: models.RestrictionMaintenance, RestrictionName: "Maintenance"},
+		{ID: models.RestrictionClosure, RestrictionName: "Seasonal Closure"},
+	}
+	return restrictions, nil
+}
+
+func (m *testDBRepo) ListBlocks(ctx context.Context, from time.Time) ([]models.RoomRestriction, error) {
+	blocks := []models.RoomRestriction{
+		{
+			ID:            7,
+			StartDate:     from.AddDate(0, 0, 5),
+			EndDate:       from.AddDate(0, 0, 8),
+			RoomId:        2,
+			RestrictionId: models.RestrictionMaintenance,
+			Room:          models.Room{ID: 2, RoomName: "Major's Suite"},
+			Restriction:   models.Restriction{ID: models.RestrictionMaintenance, RestrictionName: "Maintenance"},
+		},
+	}
+	return blocks, nil
+}
+
+func (m *testDBRepo) CreateBlock(ctx context.Context, block models.RoomRestriction) error {
+	// room 1000 fails the insert, and the dates follow SearchAvailabilityByDatesByRoomId
+	if block.RoomId == 1000 {
+		return errors.New("some error")
+	}
+	available, err := m.SearchAvailabilityByDatesByRoomId(ctx, block.StartDate, block.EndDate, block.RoomId)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	// 99 is not a block, anything above fails
+	if id == 99 {
+		return sql.ErrNoRows
+	}
+	if id > 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if room.RoomName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if room.RoomName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	// room 1 has reservations, 99 does not exist and anything above fails
+	switch {
+	case id == 1:
+		return repository.ErrRoomHasReservations
+	case id == 99:
+		return sql.ErrNoRows
+	case id > 99:
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	switch slug {
+	case "generals-quarters":
+		return models.Room{
+			ID:          1,
+			RoomName:    "General's Quarters",
+			Slug:        slug,
+			Description: "A room fit for a general",
+			Amenities:   []string{"Ocean view", "Breakfast included"},
+			Capacity:    2,
+			Active:      true,
+			Photos:      []models.RoomPhoto{{ID: 1, RoomId: 1, FileName: "generals-quarters.png"}},
+		}, nil
+	case "majors-suite":
+		return models.Room{ID: 2, RoomName: "Major's Suite", Slug: slug, Capacity: 4, Active: true}, nil
+	case "closed":
+		return models.Room{ID: 5, RoomName: "Closed Room", Slug: slug}, nil
+	case "error":
+		return models.Room{}, errors.New("some error")
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetRoomRates(ctx context.Context, roomId int) ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+
+	if roomId == 1 {
+		rates = append(rates, models.RoomRate{ID: 1, RoomId: 1, Name: "Summer",
+			StartDate: time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 8, 31, 0, 0, 0, 0, time.UTC),
+			NightlyRate: 20000})
+	}
+	return rates, nil
+}
+
+func (m *testDBRepo) GetRoomRatesByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRate, error) {
+	// stays starting in 2039 fail to load their seasons
+	if start.Year() == 2039 {
+		return nil, errors.New("some error")
+	}
+	return m.GetRoomRates(ctx, roomId)
+}
+
+func (m *testDBRepo) InsertRoomRate(ctx context.Context, rate models.RoomRate) error {
+	if rate.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomRate(ctx context.Context, roomId, id int) error {
+	switch {
+	case id == 99:
+		return sql.ErrNoRows
+	case id > 99:
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testRoomCalendars are the room calendars of the test repository, by id. Nothing listens on
+// their port, so syncing them fails
+var testRoomCalendars = map[int]models.RoomCalendar{
+	1: {ID: 1, RoomId: 1, Name: "Other Channel", URL: "http://127.0.0.1:1/calendar.ics",
+		LastSyncedAt: time.Date(2050, 1, 1, 9, 0, 0, 0, time.UTC)},
+	2: {ID: 2, RoomId: 1, Name: "Broken Channel", URL: "http://127.0.0.1:1/broken.ics",
+		LastError: "feed returned 404 Not Found"},
+}
+
+func (m *testDBRepo) AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error) {
+	return []models.RoomCalendar{testRoomCalendars[1], testRoomCalendars[2]}, nil
+}
+
+func (m *testDBRepo) GetRoomCalendars(ctx context.Context, roomId int) ([]models.RoomCalendar, error) {
+	var calendars []models.RoomCalendar
+	if roomId == 1 {
+		calendars = append(calendars, testRoomCalendars[1], testRoomCalendars[2])
+	}
+	return calendars, nil
+}
+
+func (m *testDBRepo) GetRoomCalendarByID(ctx context.Context, id int) (models.RoomCalendar, error) {
+	if id > 99 {
+		return models.RoomCalendar{}, errors.New("some error")
+	}
+	cal, ok := testRoomCalendars[id]
+	if !ok {
+		return models.RoomCalendar{}, sql.ErrNoRows
+	}
+	return cal, nil
+}
+
+func (m *testDBRepo) InsertRoomCalendar(ctx context.Context, cal models.RoomCalendar) error {
+	if cal.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomCalendar(ctx context.Context, roomId, id int) error {
+	switch {
+	case id == 99:
+		return sql.ErrNoRows
+	case id > 99:
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) SyncRoomCalendar(ctx context.Context, cal models.RoomCalendar, blocks []models.RoomRestriction) (models.CalendarSync, error) {
+	return models.CalendarSync{Added: len(blocks)}, nil
+}
+
+func (m *testDBRepo) UpdateRoomCalendarStatus(ctx context.Context, id int, lastError string) error {
+	return nil
+}
+
+// testPromoCodes are the promo codes of the test repository, by id
+var testPromoCodes = map[int]models.PromoCode{
+	1: {ID: 1, Code: "SUMMER10", DiscountType: models.DiscountPercent, Amount: 10, MinNights: 1, Active: true,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC)},
+	2: {ID: 2, Code: "LASTONE", DiscountType: models.DiscountFixed, Amount: 2500, MinNights: 1, MaxRedemptions: 1, Active: true,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC)},
+	3: {ID: 3, Code: "EXPIRED", DiscountType: models.DiscountPercent, Amount: 50, MinNights: 1, Active: true, RoomIds: []int{2},
+		StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2040, 1, 31, 0, 0, 0, 0, time.UTC)},
+}
+
+func (m *testDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	return []models.PromoCode{testPromoCodes[1], testPromoCodes[2], testPromoCodes[3]}, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	if id > 99 {
+		return models.PromoCode{}, errors.New("some error")
+	}
+	code, ok := testPromoCodes[id]
+	if !ok {
+		return code, sql.ErrNoRows
+	}
+	return code, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	if strings.EqualFold(code, "error") {
+		return models.PromoCode{}, errors.New("some error")
+	}
+	for _, c := range testPromoCodes {
+		if strings.EqualFold(c.Code, code) {
+			return c, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertPromoCode(ctx context.Context, code models.PromoCode) (int, error) {
+	if code.Code == "FAIL" {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+func (m *testDBRepo) UpdatePromoCode(ctx context.Context, code models.PromoCode) error {
+	if code.Code == "FAIL" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	// SUMMER10 has been used, 99 does not exist and anything above fails
+	switch {
+	case id == 1:
+		return repository.ErrPromoCodeRedeemed
+	case id == 99:
+		return sql.ErrNoRows
+	case id > 99:
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testMail are the messages in the outbox of the test repository, by id
+var testMail = map[int]models.MailMessage{
+	1: {ID: 1, Mail: models.MailData{To: "john@smith.com", From: "bookings@example.com", Subject: "Reservation confirmation",
+		Content: "<p>See you soon</p>", PlainContent: "See you soon"}, Status: models.MailSent, Attempts: 1,
+		Deliveries: []models.MailDelivery{{ID: 1, MailId: 1, Attempt: 1, Status: models.MailSent}}},
+	2: {ID: 2, Mail: models.MailData{To: "jane@smith.com", From: "bookings@example.com", Subject: "Reservation cancelled",
+		Content: "<p>Sorry to see you go</p>"}, Status: models.MailFailed, Attempts: 2, LastError: "connection refused",
+		Deliveries: []models.MailDelivery{
+			{ID: 2, MailId: 2, Attempt: 1, Status: models.MailFailed, Error: "connection refused"},
+			{ID: 3, MailId: 2, Attempt: 2, Status: models.MailFailed, Error: "connection refused"},
+		}},
+	3: {ID: 3, Mail: models.MailData{To: "owner@example.com", From: "bookings@example.com", Subject: "New reservation"},
+		Status: models.MailPending},
+}
+
+func (m *testDBRepo) InsertMail(ctx context.Context, msg models.MailData) (int, error) {
+	if msg.To == "fail@example.com" {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+func (m *testDBRepo) ClaimMail(ctx context.Context, limit int) ([]models.MailMessage, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) RecordMailAttempt(ctx context.Context, id int, status, errorMessage string, nextAttempt time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) ListMail(ctx context.Context, status string, limit int) ([]models.MailMessage, error) {
+	var messages []models.MailMessage
+	for _, id := range []int{3, 2, 1} {
+		if status == "" || testMail[id].Status == status {
+			messages = append(messages, testMail[id])
+		}
+	}
+	return messages, nil
+}
+
+func (m *testDBRepo) GetMailByID(ctx context.Context, id int) (models.MailMessage, error) {
+	if id > 99 {
+		return models.MailMessage{}, errors.New("some error")
+	}
+	msg, ok := testMail[id]
+	if !ok {
+		return models.MailMessage{}, sql.ErrNoRows
+	}
+	return msg, nil
+}
+
+func (m *testDBRepo) ResendMail(ctx context.Context, id int) error {
+	if id > 99 {
+		return errors.New("some error")
+	}
+	msg, ok := testMail[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if msg.Status == models.MailPending {
+		return repository.ErrMailQueued
+	}
+	return nil
+}
+
+func (m *testDBRepo) CountMailByStatus(ctx context.Context) (map[string]int, error) {
+	return map[string]int{models.MailPending: 1, models.MailSent: 1, models.MailFailed: 1}, nil
+}
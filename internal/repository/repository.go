@@ -1,19 +1,91 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"github.com/Seician/bookings/internal/models"
 	"time"
 )
 
+// ErrRoomUnavailable is returned when a room is already booked or blocked for the requested dates
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrRoomInactive is returned when booking a room that has been deactivated
+var ErrRoomInactive = errors.New("room is not taking bookings")
+
+// ErrRoomHasReservations is returned when deleting a room that has been booked
+var ErrRoomHasReservations = errors.New("room has reservations")
+
+// ErrNotCancellable is returned when cancelling a reservation that is already cancelled or
+// whose guest has already arrived
+var ErrNotCancellable = errors.New("reservation can no longer be cancelled")
+
+// ErrInvalidStatusTransition is returned when moving a reservation to a status that
+// models.ReservationTransitions doesn't allow from its current one
+var ErrInvalidStatusTransition = errors.New("reservation can't move to that status")
+
+// ErrPromoCodeUsedUp is returned when a reservation redeems a promo code that has no redemptions left
+var ErrPromoCodeUsedUp = errors.New("promo code has been used up")
+
+// ErrPromoCodeRedeemed is returned when deleting a promo code that bookings have already used
+var ErrPromoCodeRedeemed = errors.New("promo code has been redeemed")
+
+// ErrMailQueued is returned when resending a message that is still waiting to be sent
+var ErrMailQueued = errors.New("message is already waiting to be sent")
+
 type DatabaseRepo interface {
-	AllUsers() bool
-
-	InsertReservation(reservation models.Reservation) (int64, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomById(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
+	AllUsers(ctx context.Context) bool
+
+	InsertReservation(ctx context.Context, reservation models.Reservation) (int64, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	CreateReservation(ctx context.Context, reservation models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	GetRoomRates(ctx context.Context, roomId int) ([]models.RoomRate, error)
+	GetRoomRatesByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRate, error)
+	InsertRoomRate(ctx context.Context, rate models.RoomRate) error
+	DeleteRoomRate(ctx context.Context, roomId, id int) error
+	AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error)
+	GetRoomCalendars(ctx context.Context, roomId int) ([]models.RoomCalendar, error)
+	GetRoomCalendarByID(ctx context.Context, id int) (models.RoomCalendar, error)
+	InsertRoomCalendar(ctx context.Context, cal models.RoomCalendar) error
+	DeleteRoomCalendar(ctx context.Context, roomId, id int) error
+	SyncRoomCalendar(ctx context.Context, cal models.RoomCalendar, blocks []models.RoomRestriction) (models.CalendarSync, error)
+	UpdateRoomCalendarStatus(ctx context.Context, id int, lastError string) error
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	InsertPromoCode(ctx context.Context, code models.PromoCode) (int, error)
+	UpdatePromoCode(ctx context.Context, code models.PromoCode) error
+	DeletePromoCode(ctx context.Context, id int) error
+	ListReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, res models.Reservation) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int) error
+	GetReservationByToken(ctx context.Context, token string) (models.Reservation, error)
+	UpdateReservationStatus(ctx context.Context, id int, status string) error
+	UpdatePaymentStatus(ctx context.Context, paymentId, status string) error
+	InvoiceForReservation(ctx context.Context, draft models.Invoice) (models.Invoice, error)
+	CancelReservation(ctx context.Context, id int) error
+	GetRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+	AllRestrictions(ctx context.Context) ([]models.Restriction, error)
+	ListBlocks(ctx context.Context, from time.Time) ([]models.RoomRestriction, error)
+	CreateBlock(ctx context.Context, block models.RoomRestriction) error
+	DeleteBlockByID(ctx context.Context, id int) error
+	InsertMail(ctx context.Context, msg models.MailData) (int, error)
+	ClaimMail(ctx context.Context, limit int) ([]models.MailMessage, error)
+	RecordMailAttempt(ctx context.Context, id int, status, errorMessage string, nextAttempt time.Time) error
+	ListMail(ctx context.Context, status string, limit int) ([]models.MailMessage, error)
+	GetMailByID(ctx context.Context, id int) (models.MailMessage, error)
+	ResendMail(ctx context.Context, id int) error
+	CountMailByStatus(ctx context.Context) (map[string]int, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
 }